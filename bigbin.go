@@ -4,20 +4,95 @@
 package bigbin
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 )
 
 // app Main function type
 type MainFunc func()
 
-// apps the bigbin contain, that is can become
-var apps = make(map[string]MainFunc)
+// MainFuncE is an app Main function type that gets the command line arguments, without the program name,
+// and returns the exit status of the app instead of calling os.Exit itself
+type MainFuncE func(args []string) int
+
+// App describes an application bundled within the bigbin
+type App struct {
+	Name    string    // Name to invoke the app as, either as argv[0] or as subcommand
+	Aliases []string  // Aliases are alternative names to invoke the app
+	Short   string    // Short is a one line description of the app
+	Long    string    // Long is the full description of the app
+	Version string    // Version of the app, if any
+	Hidden  bool      // Hidden apps are still invocable, but not listed nor linked
+	Main    MainFunc  // Main function of the app
+	MainE   MainFuncE // MainE is the exit status aware Main alternative, only used when Main is nil
+
+	// Flags is the app own flag set, if any, installed as flag.CommandLine right before running the app,
+	// so that apps registering the same flag names do not collide
+	Flags *flag.FlagSet
+
+	// Init is the app deferred initialization, if any, run only once and only when the app is run,
+	// instead of on every bigbin invocation like the package init functions
+	Init func()
+}
+
+// apps the bigbin contain, that is can become, indexed by name and aliases
+var apps = make(map[string]*App)
+
+// initialized tracks the apps whose Init already ran
+var initialized = make(map[*App]bool)
+
+// commandLine is the original flag.CommandLine, holding the flags registered there by libraries
+var commandLine = flag.CommandLine
+
+// Register registers an app to be invoked by its name or any of its aliases.
+//
+// Register panics if the app has no name nor Main or MainE, or if any of its names was already registered.
+func Register(app App) {
+	if app.Name == "" || (app.Main == nil && app.MainE == nil) {
+		panic(fmt.Sprintf("bigbin: app %q registered without name or Main", app.Name))
+	}
+	for _, name := range app.Names() {
+		if _, ok := apps[name]; ok {
+			panic(fmt.Sprintf("bigbin: app %q registered twice", name))
+		}
+	}
+	registered := &app
+	for _, name := range app.Names() {
+		apps[name] = registered
+	}
+}
 
 // AddApp registers an appName to invoke the given appMain
 func AddApp(appName string, appMain MainFunc) {
-	apps[appName] = appMain
+	Register(App{Name: appName, Main: appMain})
+}
+
+// AddAppE registers an appName to invoke the given exit status aware appMain
+func AddAppE(appName string, appMain MainFuncE) {
+	Register(App{Name: appName, MainE: appMain})
+}
+
+// Apps returns all registered and non hidden apps sorted by name
+func Apps() []*App {
+	list := make([]*App, 0, len(apps))
+	for name, app := range apps {
+		if name == app.Name && !app.Hidden {
+			list = append(list, app)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Names returns the name followed by all aliases of the app
+func (app *App) Names() []string {
+	return append([]string{app.Name}, app.Aliases...)
 }
 
 func dieOnError(err error) {
@@ -27,28 +102,129 @@ func dieOnError(err error) {
 	}
 }
 
-// Main runs the app named as the command line first argument
+// Main runs the app named as the command line first argument.
+//
+// If the first argument does not name a registered app, the second argument is tried instead,
+// so that "mybigbin appa args..." runs appa with os.Args shifted as if invoked as "appa args...".
+//
+// Otherwise, when invoked as the bigbin executable itself, it supports installing and uninstalling
+// all apps into a directory, see "mybigbin --help".
 func Main() {
-	cmd := os.Args[0]
-	processFilename, err := filepath.EvalSymlinks(cmd)
-	dieOnError(err)
-	rootName := filepath.Base(processFilename)
-	appName := filepath.Base(cmd)
+	appName := filepath.Base(os.Args[0])
 	// Invoke the appName, if registered
-	if appMain, ok := apps[appName]; ok {
-		appMain()
-	} else if appName == rootName { // Otherwise, if it is the root process filename, rebuild the symslinks
-		fmt.Println("Rebuilding symlinks in current directory:")
-		for app, _ := range apps {
-			fmt.Printf(" %s -> %s\n", processFilename, app)
-			os.Symlink(processFilename, app)
+	if app, ok := apps[appName]; ok {
+		exit(runApp(app, flag.ExitOnError))
+		return
+	}
+	// Or invoke the app named by the subcommand, if registered
+	if len(os.Args) > 1 {
+		if app, ok := apps[os.Args[1]]; ok {
+			os.Args = os.Args[1:]
+			exit(runApp(app, flag.ExitOnError))
+			return
 		}
-	} else { // if all above fails, then output an error with some help and exit
-		fmt.Fprintf(os.Stderr, "%s app not added into this bigbin!\n", appName)
-		fmt.Fprintf(os.Stderr, "Registered apps are:\n")
-		for app, _ := range apps {
-			fmt.Fprintf(os.Stderr, " %s\n", app)
+	}
+	exe, err := executable()
+	dieOnError(err)
+	if appName == filepath.Base(exe) { // Otherwise, if it is the root process filename, run the root commands
+		exit(rootMain(exe, os.Args[1:]))
+		return
+	}
+	// if all above fails, then output an error with some help and exit
+	fmt.Fprintf(os.Stderr, "%s app not added into this bigbin!\n", appName)
+	fmt.Fprintf(os.Stderr, "Registered apps are:\n")
+	listApps(os.Stderr)
+	os.Exit(2)
+}
+
+// RunApp runs the named app in process, as if invoked with the given arguments, and returns its exit status.
+// Apps registered with a plain MainFunc always return 0, unless they exit the process themselves.
+//
+// Flag parsing errors and -h do not exit the process either, but return 2 and 0, as flag would exit with.
+func RunApp(name string, args ...string) (int, error) {
+	app, ok := apps[name]
+	if !ok {
+		return 0, fmt.Errorf("%s app not added into this bigbin!", name)
+	}
+	savedArgs, savedCommandLine := os.Args, flag.CommandLine
+	defer func() { os.Args, flag.CommandLine = savedArgs, savedCommandLine }()
+	os.Args = append([]string{name}, args...)
+	return runApp(app, flag.PanicOnError), nil
+}
+
+// runApp invokes the app Main making the flag.CommandLine report os.Args[0] as the program name
+// and handle errors as given, returning the app exit status.
+//
+// With flag.PanicOnError, flag parsing failures are recovered to return 2, or 0 for -h, instead.
+// Apps with their own flag set get it installed as flag.CommandLine, see UseFlags.
+func runApp(app *App, handling flag.ErrorHandling) (status int) {
+	flag.CommandLine = commandLine
+	if app.Flags != nil {
+		UseFlags(app.Flags)
+	}
+	flag.CommandLine.Init(os.Args[0], handling)
+	if handling == flag.PanicOnError {
+		// flag shows the usage right before panicking, telling its panics apart from the app ones
+		flags, usage, failed := flag.CommandLine, flag.CommandLine.Usage, false
+		flags.Usage = func() {
+			failed = true
+			usage()
+		}
+		defer func() {
+			flags.Usage = usage
+			if r := recover(); r != nil {
+				if !failed {
+					panic(r)
+				}
+				status = 2
+				if r == flag.ErrHelp {
+					status = 0
+				}
+			}
+		}()
+	}
+	if app.Init != nil && !initialized[app] {
+		initialized[app] = true
+		app.Init()
+	}
+	if app.Main == nil {
+		return app.MainE(os.Args[1:])
+	}
+	app.Main()
+	return 0
+}
+
+// UseFlags installs flags as flag.CommandLine, along with any flags registered in the original one by libraries,
+// unless flags redefines them. Generated standalone mains call it too, so apps take the same flags either way.
+func UseFlags(flags *flag.FlagSet) {
+	commandLine.VisitAll(func(f *flag.Flag) {
+		if flags.Lookup(f.Name) == nil {
+			flags.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	if flags.Usage == nil {
+		flags.Usage = func() { flag.Usage() }
+	}
+	flag.CommandLine = flags
+}
+
+// exit terminates the process with the given status, unless it is 0
+// so that the main function can return normally
+func exit(status int) {
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+// listApps writes a table of the registered apps with their aliases, versions and short descriptions
+func listApps(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, app := range Apps() {
+		name := app.Name
+		if len(app.Aliases) > 0 {
+			name = fmt.Sprintf("%s (%s)", name, strings.Join(app.Aliases, ", "))
 		}
-		os.Exit(2)
+		fmt.Fprintf(tw, " %s\t%s\t%s\n", name, app.Version, app.Short)
 	}
+	tw.Flush()
 }
@@ -1,71 +1,54 @@
 package bigbin
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
 var testData = []string{"a", "b", "app1", "app2"}
 
-const (
-	someAppPackage  = "some.org/packaged/app"
-	expectedAppName = "app"
-)
-
-const ExpectedAppMain = `package app_main
-
-import "github.com/josvazg/bigbin"
-
-func init() {
-	bigbin.Add("App",AppMain)
-}
-
-func AppMain() {
-	// paste your main here
-}
-`
-
-const ExpectedStandAloneMain = `package main 
-
-import "some.org/packaged/app"
-
-func main() {
-    app_main.AppMain()
-}
-`
-
-var expectedGenerated = [...]string{ExpectedAppMain, BigBinMainTmpl, ExpectedStandAloneMain}
-
-type generatorFunc func() string
-
-var genFuncs = []generatorFunc{
-	func() string {
-		return GenerateAppMain(someAppPackage)
-	},
-	GenerateBigBin,
-	func() string {
-		return GenerateStandAloneMain(someAppPackage)
-	},
-}
-
 const (
 	BIGBIN_APPNAME = "BIGBIN_APPNAME"
 )
 
+// rootName is the name of the test executable, which acts as the bigbin root binary
+var rootName = filepath.Base(os.Args[0])
+
 // register all names in the init, no matter what,as the real bigbin would do
 func init() {
 	for _, appName := range testData {
 		AddApp(appName, mainMaker(appName))
 	}
+	Register(App{Name: "c", Aliases: []string{"see", "cee"}, Short: "C app", Version: "1.0", Main: mainMaker("c")})
+	Register(App{Name: "hidden", Hidden: true, Main: mainMaker("hidden")})
+	for _, appName := range []string{"flags1", "flags2"} {
+		flags := flag.NewFlagSet(appName, flag.ExitOnError)
+		verbose := flags.Bool("v", false, "verbose "+appName)
+		name := appName
+		Register(App{Name: name, Hidden: true, Flags: flags, Main: func() {
+			flag.Parse()
+			fmt.Println(name, *verbose, flag.Args())
+		}})
+	}
+	AddAppE("exiter", func(args []string) int {
+		fmt.Println(strings.Join(append([]string{"exiter"}, args...), " "))
+		return len(args)
+	})
+	Register(App{Name: "lazy", Hidden: true, Init: func() { lazyInits++ }, Main: func() {}})
 }
 
-// mainMaker generates dumb MainFuncs that just return the appName
+// lazyInits counts how many times the lazy app Init ran
+var lazyInits int
+
+// mainMaker generates dumb MainFuncs that just return the appName followed by any arguments
 func mainMaker(appName string) func() {
 	return func() {
-		fmt.Println(appName)
+		fmt.Println(strings.Join(append([]string{appName}, os.Args[1:]...), " "))
 	}
 }
 
@@ -101,19 +84,181 @@ func TestBigBin(t *testing.T) {
 	}
 }
 
-// Run reinvokes the test to fake a run of appName
-func Run(appName string) ([]byte, error) {
-	cmd := exec.Command(os.Args[0])
-	cmd.Env = append(os.Environ(), BIGBIN_APPNAME+"="+appName)
-	return cmd.CombinedOutput()
+// TestSubcommand invokes each app in testData as a subcommand of the root binary
+// and checks arguments got shifted so that the app sees itself as argv[0]
+func TestSubcommand(t *testing.T) {
+	for _, appName := range testData {
+		output, err := Run(rootName, appName, "--flag", "value")
+		if err != nil {
+			t.Fatalf("BigBin failed to start subcommand %s with error: %s", appName, err)
+		}
+		expected := appName + " --flag value"
+		if strings.Trim(string(output), " \n") != expected {
+			t.Fatalf("BigBin failed to execute subcommand %s correctly expected output was '%s' but got: '%s'",
+				appName, expected, output)
+		}
+	}
+	if output, err := Run(rootName, "missing"); err == nil {
+		t.Fatalf("BigBin should have failed on an unregistered subcommand but got: '%s'", output)
+	}
+}
+
+// TestAliases checks apps are also invocable by their aliases or even if hidden
+func TestAliases(t *testing.T) {
+	for appName, expected := range map[string]string{"see": "c", "cee": "c", "hidden": "hidden"} {
+		output, err := Run(appName)
+		if err != nil {
+			t.Fatalf("BigBin failed to start app %s with error: %s", appName, err)
+		}
+		if strings.Trim(string(output), " \n") != expected {
+			t.Fatalf("BigBin failed to execute app %s as %s, got: '%s'", appName, expected, output)
+		}
+	}
+}
+
+// TestApps checks Apps lists each non hidden app once, sorted by name
+func TestApps(t *testing.T) {
+	names := []string{}
+	for _, app := range Apps() {
+		names = append(names, app.Name)
+	}
+	expected := "a app1 app2 b c exiter"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("Expected apps %s but got %v", expected, names)
+	}
+}
+
+// TestFlagSets checks apps with their own flag sets do not collide
+func TestFlagSets(t *testing.T) {
+	for appName, expected := range map[string]string{"flags1": "flags1 true [x]", "flags2": "flags2 false []"} {
+		args := []string{}
+		if appName == "flags1" {
+			args = []string{"-v", "x"}
+		}
+		output, err := Run(appName, args...)
+		if err != nil {
+			t.Fatalf("BigBin failed to start app %s with error: %s: %s", appName, err, output)
+		}
+		if strings.Trim(string(output), " \n") != expected {
+			t.Fatalf("Expected app %s to output '%s' but got: '%s'", appName, expected, output)
+		}
+	}
+}
+
+// TestExitStatus checks the exit status of MainFuncE apps becomes the process exit status
+func TestExitStatus(t *testing.T) {
+	output, err := Run("exiter", "x", "y")
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 2 {
+		t.Fatalf("Expected exiter to exit with status 2 but got: %v", err)
+	}
+	if strings.Trim(string(output), " \n") != "exiter x y" {
+		t.Fatalf("Unexpected exiter output: '%s'", output)
+	}
+	if output, err := Run("exiter"); err != nil {
+		t.Fatalf("Expected exiter to exit with status 0 but got: %v: %s", err, output)
+	}
+}
+
+// TestRunApp checks apps can be run in process
+func TestRunApp(t *testing.T) {
+	if status, err := RunApp("exiter", "1", "2", "3"); err != nil || status != 3 {
+		t.Fatalf("Expected in process exiter to return 3 but got %d, %v", status, err)
+	}
+	if _, err := RunApp("missing"); err == nil {
+		t.Fatalf("RunApp should have failed for an unregistered app")
+	}
+}
+
+// TestRunAppFlagErrors checks bad flags and -h return the exit status flag would exit with, in process,
+// while app panics still go through
+func TestRunAppFlagErrors(t *testing.T) {
+	for args, expected := range map[string]int{"-bad": 2, "-h": 0, "-v": 0} {
+		if status, err := RunApp("flags1", args); err != nil || status != expected {
+			t.Fatalf("Expected flags1 %s to return %d but got %d, %v", args, expected, status, err)
+		}
+	}
+	defer func() {
+		if r := recover(); r != "app panic" {
+			t.Fatalf("Expected the app panic to go through but got %v", r)
+		}
+	}()
+	apps["panicker"] = &App{Name: "panicker", Main: func() { panic("app panic") }}
+	defer delete(apps, "panicker")
+	RunApp("panicker")
+}
+
+// libDebug is a flag registered by a library, into the original flag.CommandLine
+var libDebug = flag.Bool("libdebug", false, "library debug flag")
+
+// TestUseFlags checks an app flag set takes the library flags too, as a generated standalone main does
+func TestUseFlags(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine, *libDebug = original, false }()
+	flags := flag.NewFlagSet("standalone", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "verbose")
+	UseFlags(flags)
+	if flag.CommandLine != flags {
+		t.Fatalf("UseFlags should have installed the app flag set as flag.CommandLine")
+	}
+	if err := flag.CommandLine.Parse([]string{"-libdebug", "-v", "z"}); err != nil {
+		t.Fatalf("Parsing app and library flags failed: %v", err)
+	}
+	if !*libDebug || !*verbose || strings.Join(flag.Args(), " ") != "z" {
+		t.Fatalf("Expected -libdebug, -v and z but got %v, %v and %v", *libDebug, *verbose, flag.Args())
+	}
 }
 
-// TestGenerate invokes bigbin.Generate and validates in outputs the expected code
-func TestGenerate(t *testing.T) {
-	for i := 0; i < len(genFuncs); i++ {
-		generatedMain := genFuncs[i]()
-		if generatedMain != expectedGenerated[i] {
-			t.Fatalf("Expected generated main was:\n%s\nBut got:\n%s", expectedGenerated[i], generatedMain)
+// TestRunAppsInARow checks running an app in process does not leak its flags into the next one
+func TestRunAppsInARow(t *testing.T) {
+	original := flag.CommandLine
+	for _, name := range []string{"first", "second"} {
+		flags := flag.NewFlagSet(name, flag.ContinueOnError)
+		flags.Bool(name, false, name+" only flag")
+		apps[name] = &App{Name: name, Flags: flags, Main: func() { flag.Parse() }}
+		defer delete(apps, name)
+	}
+	for _, name := range []string{"first", "second"} {
+		if _, err := RunApp(name, "-"+name); err != nil {
+			t.Fatalf("RunApp %s failed: %v", name, err)
 		}
+		if flag.CommandLine != original {
+			t.Fatalf("RunApp %s should have restored flag.CommandLine", name)
+		}
+	}
+	if apps["second"].Flags.Lookup("first") != nil {
+		t.Fatalf("The first app flags leaked into the second app")
+	}
+}
+
+// TestLazyInit checks app Init runs only when the app is run, and just once
+func TestLazyInit(t *testing.T) {
+	if _, err := RunApp("exiter"); err != nil || lazyInits != 0 {
+		t.Fatalf("Running another app should not init lazy, got %d inits, %v", lazyInits, err)
 	}
+	for i := 0; i < 2; i++ {
+		if _, err := RunApp("lazy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lazyInits != 1 {
+		t.Fatalf("Expected lazy to be initialized once but got %d", lazyInits)
+	}
+}
+
+// TestRegisterTwice checks that registering an already registered name panics
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Registering an alias twice should have panicked")
+		}
+	}()
+	Register(App{Name: "other", Aliases: []string{"see"}, Main: mainMaker("other")})
+}
+
+// Run reinvokes the test to fake a run of appName with the given args
+func Run(appName string, args ...string) ([]byte, error) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), BIGBIN_APPNAME+"="+appName)
+	return cmd.CombinedOutput()
 }
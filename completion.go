@@ -0,0 +1,203 @@
+package bigbin
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// completionFlag is a flag offered for completion, as typed, along with its usage
+type completionFlag struct {
+	flag, usage string
+}
+
+// completionApp is a non hidden app offered for completion
+type completionApp struct {
+	names []string         // names are the app name and aliases
+	short string           // short description of the app
+	flags []completionFlag // flags of the app own flag set, if any
+}
+
+// writeCompletion writes the shell completion script for the rootName bigbin, completing app names and
+// rootFlags when invoked as rootName, and app flags both as "rootName app -<TAB>" and as "app -<TAB>"
+func writeCompletion(w io.Writer, rootName, shell string, rootFlags *flag.FlagSet) error {
+	roots := []completionFlag{}
+	rootFlags.VisitAll(func(f *flag.Flag) {
+		prefix := "--"
+		if len(f.Name) == 1 {
+			prefix = "-"
+		}
+		roots = append(roots, completionFlag{prefix + f.Name, firstLine(f.Usage)})
+	})
+	completionApps, names := []completionApp{}, []string{}
+	for _, app := range Apps() {
+		completing := completionApp{names: app.Names(), short: app.Short}
+		if app.Flags != nil {
+			app.Flags.VisitAll(func(f *flag.Flag) {
+				completing.flags = append(completing.flags, completionFlag{"-" + f.Name, firstLine(f.Usage)})
+			})
+		}
+		completionApps = append(completionApps, completing)
+		names = append(names, app.Names()...)
+	}
+	sort.Strings(names)
+	fn := "_" + nonIdentifier.ReplaceAllString(rootName, "_")
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, rootName, fn, names, roots, completionApps)
+	case "zsh":
+		writeZshCompletion(w, rootName, fn, names, roots, completionApps)
+	case "fish":
+		writeFishCompletion(w, rootName, roots, completionApps)
+	default:
+		return fmt.Errorf("Unsupported shell %q for completion, use bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+// nonIdentifier matches the characters not allowed in shell function names
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// writeBashCompletion writes the bash script, completing names as the first word of rootName and flags per app
+func writeBashCompletion(w io.Writer, rootName, fn string, names []string, roots []completionFlag,
+	completionApps []completionApp) {
+	words := append([]string{}, names...)
+	for _, root := range roots {
+		words = append(words, root.flag)
+	}
+	fmt.Fprintf(w, "# bash completion for %s and its apps, load it with: source <(%s --completion bash)\n",
+		rootName, rootName)
+	fmt.Fprintf(w, "%s_flags() {\n\tcase \"$1\" in\n", fn)
+	for _, app := range completionApps {
+		if len(app.flags) > 0 {
+			flags := []string{}
+			for _, f := range app.flags {
+				flags = append(flags, f.flag)
+			}
+			fmt.Fprintf(w, "\t%s) echo %s ;;\n", strings.Join(quoteAll(app.names), "|"),
+				quote(strings.Join(flags, " ")))
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n}\n\n")
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} app=${COMP_WORDS[0]##*/}\n")
+	fmt.Fprintf(w, "\tif [[ $app == %s ]]; then\n", quote(rootName))
+	fmt.Fprintf(w, "\t\tif [[ $COMP_CWORD -eq 1 ]]; then\n")
+	fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", quote(strings.Join(words, " ")))
+	fmt.Fprintf(w, "\t\t\treturn\n\t\tfi\n\t\tapp=${COMP_WORDS[1]}\n\tfi\n")
+	fmt.Fprintf(w, "\tif [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"$(%s_flags \"$app\")\" -- \"$cur\"))\n\tfi\n}\n\n", fn)
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", fn, strings.Join(quoteAll(append([]string{rootName}, names...)), " "))
+}
+
+// writeZshCompletion writes the zsh script, describing the apps and flags being completed
+func writeZshCompletion(w io.Writer, rootName, fn string, names []string, roots []completionFlag,
+	completionApps []completionApp) {
+	commands := strings.Join(quoteAll(append([]string{rootName}, names...)), " ")
+	described := []string{}
+	for _, app := range completionApps {
+		for _, name := range app.names {
+			described = append(described, zshDescribe(name, app.short))
+		}
+	}
+	sort.Strings(described)
+	fmt.Fprintf(w, "#compdef %s\n", commands)
+	fmt.Fprintf(w, "# zsh completion for %s and its apps, load it with: source <(%s --completion zsh)\n",
+		rootName, rootName)
+	fmt.Fprintf(w, "%s() {\n\tlocal app=${words[1]:t}\n\tlocal -a flags\n", fn)
+	fmt.Fprintf(w, "\tif [[ $app == %s ]]; then\n\t\tif (( CURRENT == 2 )); then\n", quote(rootName))
+	fmt.Fprintf(w, "\t\t\tlocal -a apps=(%s)\n", strings.Join(described, " "))
+	fmt.Fprintf(w, "\t\t\tlocal -a roots=(%s)\n", strings.Join(zshDescribeFlags(roots), " "))
+	fmt.Fprintf(w, "\t\t\t_describe -t apps 'app' apps\n\t\t\t_describe -t options 'option' roots\n")
+	fmt.Fprintf(w, "\t\t\treturn\n\t\tfi\n\t\tapp=${words[2]}\n\tfi\n\tcase $app in\n")
+	for _, app := range completionApps {
+		if len(app.flags) > 0 {
+			fmt.Fprintf(w, "\t%s) flags=(%s) ;;\n", strings.Join(quoteAll(app.names), "|"),
+				strings.Join(zshDescribeFlags(app.flags), " "))
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tif [[ $PREFIX == -* ]] && (( $#flags )); then\n\t\t_describe -t flags 'flag' flags\n")
+	fmt.Fprintf(w, "\telse\n\t\t_files\n\tfi\n}\n\n")
+	fmt.Fprintf(w, "compdef %s %s\n", fn, commands)
+}
+
+// zshDescribe returns the _describe "value:description" entry for value, quoted
+func zshDescribe(value, description string) string {
+	return quote(strings.ReplaceAll(value, ":", `\:`) + ":" + description)
+}
+
+// zshDescribeFlags returns the _describe entries for flags, quoted
+func zshDescribeFlags(flags []completionFlag) []string {
+	described := []string{}
+	for _, f := range flags {
+		described = append(described, zshDescribe(f.flag, f.usage))
+	}
+	return described
+}
+
+// writeFishCompletion writes the fish script, a complete command per app name, root flag and app flag
+func writeFishCompletion(w io.Writer, rootName string, roots []completionFlag, completionApps []completionApp) {
+	fmt.Fprintf(w, "# fish completion for %s and its apps, load it with: %s --completion fish | source\n",
+		rootName, rootName)
+	root := quote(rootName)
+	for _, app := range completionApps {
+		for _, name := range app.names {
+			fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", root, quote(name), quote(app.short))
+		}
+	}
+	for _, f := range roots {
+		fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand %s -d %s\n", root, fishFlag(f.flag), quote(f.usage))
+	}
+	for _, app := range completionApps {
+		seen := quote("__fish_seen_subcommand_from " + strings.Join(app.names, " "))
+		for _, f := range app.flags {
+			fmt.Fprintf(w, "complete -c %s -n %s %s -d %s\n", root, seen, fishFlag(f.flag), quote(f.usage))
+			for _, name := range app.names {
+				fmt.Fprintf(w, "complete -c %s %s -d %s\n", quote(name), fishFlag(f.flag), quote(f.usage))
+			}
+		}
+	}
+}
+
+// fishFlag returns the complete option describing flag: -s for "-x", -l for "--name" and -o for "-name"
+func fishFlag(flag string) string {
+	name := strings.TrimLeft(flag, "-")
+	switch {
+	case len(name) == 1:
+		return "-s " + quote(name)
+	case strings.HasPrefix(flag, "--"):
+		return "-l " + quote(name)
+	default:
+		return "-o " + quote(name)
+	}
+}
+
+// quote single quotes s for the shell, unless it is only made of safe characters
+func quote(s string) string {
+	if s != "" && !unsafeShell.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// unsafeShell matches the characters that need quoting in the shell
+var unsafeShell = regexp.MustCompile(`[^A-Za-z0-9_./+-]`)
+
+// quoteAll quotes each of words for the shell, see quote
+func quoteAll(words []string) []string {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, quote(word))
+	}
+	return quoted
+}
+
+// firstLine returns the first line of text
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
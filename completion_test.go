@@ -0,0 +1,71 @@
+package bigbin
+
+import (
+	"bytes"
+	"flag"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestCompletion checks the completion scripts offer the non hidden app names and aliases, and root flags
+func TestCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		output, err := Run(rootName, "--completion", shell)
+		if err != nil {
+			t.Fatalf("Completion %s failed: %v: %s", shell, err, output)
+		}
+		script := string(output)
+		expected := append([]string{"install"}, installedNames...)
+		if shell != "bash" { // bash completion has no descriptions
+			expected = append(expected, "C app")
+		}
+		for _, expected := range expected {
+			if !strings.Contains(script, expected) {
+				t.Fatalf("Completion %s should offer %q, but got:\n%s", shell, expected, script)
+			}
+		}
+		if strings.Contains(script, "hidden") {
+			t.Fatalf("Completion %s should not offer hidden apps, but got:\n%s", shell, script)
+		}
+		if bash, err := exec.LookPath("bash"); err == nil && shell == "bash" {
+			if output, err := exec.Command(bash, "-n", "-c", script).CombinedOutput(); err != nil {
+				t.Fatalf("Completion bash is not valid: %v: %s", err, output)
+			}
+		}
+	}
+	for _, args := range [][]string{{"--completion", "tcsh"}, {"--completion", "bash", "dir"}, {"--completion", "bash", "--install"}} {
+		if output, err := Run(rootName, args...); err == nil {
+			t.Fatalf("Root invocation with %v should have failed, but got: %s", args, output)
+		}
+	}
+}
+
+// TestCompletionFlags checks app flags are completed both as subcommand and by app name
+func TestCompletionFlags(t *testing.T) {
+	flags := flag.NewFlagSet("flagged", flag.ExitOnError)
+	flags.Bool("verbose", false, "verbose output")
+	flags.Bool("q", false, "quiet")
+	flagged := &App{Name: "flagged", Aliases: []string{"flg"}, Flags: flags, Main: func() {}}
+	apps["flagged"], apps["flg"] = flagged, flagged
+	defer func() { delete(apps, "flagged"); delete(apps, "flg") }()
+	expected := map[string][]string{
+		"bash": {"flagged|flg) echo '-q -verbose' ;;"},
+		"zsh":  {"flagged|flg) flags=('-q:quiet' '-verbose:verbose output') ;;"},
+		"fish": {
+			"complete -c root -n '__fish_seen_subcommand_from flagged flg' -o verbose -d 'verbose output'",
+			"complete -c flg -s q -d quiet",
+		},
+	}
+	for shell, lines := range expected {
+		var script bytes.Buffer
+		if err := writeCompletion(&script, "root", shell, flag.NewFlagSet("root", flag.ContinueOnError)); err != nil {
+			t.Fatalf("Completion %s failed: %v", shell, err)
+		}
+		for _, line := range lines {
+			if !strings.Contains(script.String(), line) {
+				t.Fatalf("Completion %s should contain %q, but got:\n%s", shell, line, script.String())
+			}
+		}
+	}
+}
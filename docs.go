@@ -0,0 +1,186 @@
+package bigbin
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// docFlag describes a flag for the documentation pages
+type docFlag struct {
+	flag, value, usage, defValue string
+}
+
+// docPage is the documentation of an app, or of the bigbin itself
+type docPage struct {
+	name, short, long, version string
+	aliases, synopsis          []string
+	flags                      []docFlag
+	related                    []*App // related are the apps listed by the bigbin page
+}
+
+// writeDocs writes into dir a page per non hidden app, named after it, plus an index page for the rootName
+// bigbin listing them all along with rootFlags, in the given format: man (section 1 pages) or markdown
+func writeDocs(rootName, format, dir string, rootFlags *flag.FlagSet) error {
+	write, ext := writeMan, ".1"
+	switch format {
+	case "man":
+	case "markdown":
+		write, ext = writeMarkdown, ".md"
+	default:
+		return fmt.Errorf("Unsupported docs format %q, use man or markdown", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	index := docPage{name: rootName, short: fmt.Sprintf("big binary bundling %d apps", len(Apps())),
+		synopsis: []string{rootName + " APP [args...]"}, flags: docFlags(rootFlags, "--"), related: Apps()}
+	pages := []docPage{index}
+	for _, app := range Apps() {
+		page := docPage{name: app.Name, short: app.Short, long: app.Long, version: app.Version, aliases: app.Aliases,
+			synopsis: []string{app.Name + " [flags] [args...]", rootName + " " + app.Name + " [flags] [args...]"}}
+		if app.Flags != nil {
+			page.flags = docFlags(app.Flags, "-")
+		}
+		page.related = []*App{{Name: rootName}}
+		pages = append(pages, page)
+	}
+	for _, page := range pages {
+		var text strings.Builder
+		write(&text, page, ext)
+		filename := filepath.Join(dir, page.name+ext)
+		if err := os.WriteFile(filename, []byte(text.String()), 0644); err != nil {
+			return err
+		}
+		fmt.Printf(" %s\n", filename)
+	}
+	return nil
+}
+
+// docFlags lists the flags of flags, prefixed by prefix but for the one letter ones, always prefixed by "-"
+func docFlags(flags *flag.FlagSet, prefix string) []docFlag {
+	list := []docFlag{}
+	flags.VisitAll(func(f *flag.Flag) {
+		value, usage := flag.UnquoteUsage(f)
+		name := prefix + f.Name
+		if len(f.Name) == 1 {
+			name = "-" + f.Name
+		}
+		defValue := f.DefValue
+		if defValue == "false" || defValue == "0" {
+			defValue = ""
+		}
+		list = append(list, docFlag{name, value, usage, defValue})
+	})
+	return list
+}
+
+// writeMan writes page as a man page
+func writeMan(text *strings.Builder, page docPage, ext string) {
+	fmt.Fprintf(text, ".TH %s 1 \"\" %s\n", roff(strings.ToUpper(page.name)),
+		roffQuote(strings.TrimSpace(page.name+" "+page.version)))
+	fmt.Fprintf(text, ".SH NAME\n%s", roff(page.name))
+	if page.short != "" {
+		fmt.Fprintf(text, " \\- %s", roff(page.short))
+	}
+	text.WriteString("\n.SH SYNOPSIS\n")
+	for i, synopsis := range page.synopsis {
+		if i > 0 {
+			text.WriteString(".br\n")
+		}
+		command, args, _ := strings.Cut(synopsis, " [")
+		fmt.Fprintf(text, ".B %s\n%s\n", roff(command), roff("["+args))
+	}
+	if page.long != "" {
+		fmt.Fprintf(text, ".SH DESCRIPTION\n%s\n", roff(strings.ReplaceAll(page.long, "\n\n", "\n.PP\n")))
+	}
+	if len(page.aliases) > 0 {
+		fmt.Fprintf(text, ".SH ALIASES\n%s\n", roff(strings.Join(page.aliases, ", ")))
+	}
+	if len(page.flags) > 0 {
+		text.WriteString(".SH OPTIONS\n")
+		for _, f := range page.flags {
+			fmt.Fprintf(text, ".TP\n.B %s", roff(f.flag))
+			if f.value != "" {
+				fmt.Fprintf(text, " \\fI%s\\fR", roff(f.value))
+			}
+			fmt.Fprintf(text, "\n%s", roff(f.usage))
+			if f.defValue != "" {
+				fmt.Fprintf(text, " (default %s)", roff(f.defValue))
+			}
+			text.WriteString("\n")
+		}
+	}
+	if len(page.related) > 0 {
+		text.WriteString(".SH SEE ALSO\n")
+		for i, app := range page.related {
+			separator := ","
+			if i == len(page.related)-1 {
+				separator = ""
+			}
+			fmt.Fprintf(text, ".BR %s (1)%s\n", roff(app.Name), separator)
+		}
+	}
+}
+
+// roff escapes text for man pages, so that backslashes, dashes and leading dots or quotes are taken literally
+func roff(text string) string {
+	text = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if (strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'")) && line != ".PP" {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// roffQuote escapes text as a double quoted man macro argument
+func roffQuote(text string) string {
+	return `"` + strings.ReplaceAll(roff(text), `"`, `""`) + `"`
+}
+
+// writeMarkdown writes page as markdown, linking the related pages with the given extension
+func writeMarkdown(text *strings.Builder, page docPage, ext string) {
+	fmt.Fprintf(text, "# %s\n\n", page.name)
+	if page.short != "" {
+		fmt.Fprintf(text, "%s\n\n", page.short)
+	}
+	if page.version != "" {
+		fmt.Fprintf(text, "Version %s\n\n", page.version)
+	}
+	fmt.Fprintf(text, "## Synopsis\n\n```\n%s\n```\n\n", strings.Join(page.synopsis, "\n"))
+	if page.long != "" {
+		fmt.Fprintf(text, "## Description\n\n%s\n\n", page.long)
+	}
+	if len(page.aliases) > 0 {
+		fmt.Fprintf(text, "## Aliases\n\n`%s`\n\n", strings.Join(page.aliases, "`, `"))
+	}
+	if len(page.flags) > 0 {
+		text.WriteString("## Flags\n\n")
+		for _, f := range page.flags {
+			name := f.flag
+			if f.value != "" {
+				name += " " + f.value
+			}
+			fmt.Fprintf(text, "- `%s`: %s", name, f.usage)
+			if f.defValue != "" {
+				fmt.Fprintf(text, " (default `%s`)", f.defValue)
+			}
+			text.WriteString("\n")
+		}
+		text.WriteString("\n")
+	}
+	if len(page.related) > 0 {
+		text.WriteString("## See also\n\n")
+		for _, app := range page.related {
+			fmt.Fprintf(text, "- [%s](%s%s)", app.Name, app.Name, ext)
+			if app.Short != "" {
+				fmt.Fprintf(text, ": %s", app.Short)
+			}
+			text.WriteString("\n")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package bigbin
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestGenDocs checks a page gets written per non hidden app, plus the bigbin index page, in both formats
+func TestGenDocs(t *testing.T) {
+	for format, ext := range map[string]string{"man": ".1", "markdown": ".md"} {
+		dir := t.TempDir()
+		if output, err := Run(rootName, "--gen-docs", format, dir); err != nil {
+			t.Fatalf("Gen docs %s failed: %v: %s", format, err, output)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := []string{}
+		for _, entry := range entries {
+			pages = append(pages, entry.Name())
+		}
+		expected := []string{rootName + ext}
+		for _, name := range []string{"a", "app1", "app2", "b", "c", "exiter"} {
+			expected = append(expected, name+ext)
+		}
+		sort.Strings(expected)
+		if strings.Join(pages, " ") != strings.Join(expected, " ") {
+			t.Fatalf("Expected %s pages %v but got %v", format, expected, pages)
+		}
+		page, err := os.ReadFile(filepath.Join(dir, "c"+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{"C app", "see, cee", "1.0", rootName} {
+			if format == "markdown" {
+				expected = strings.ReplaceAll(expected, ", ", "`, `")
+			}
+			if !strings.Contains(string(page), expected) {
+				t.Fatalf("Page c%s should contain %q, but got:\n%s", ext, expected, page)
+			}
+		}
+	}
+	for _, args := range [][]string{{"--gen-docs", "html", t.TempDir()}, {"--gen-docs", "man"}, {"--gen-docs", "man", "--completion", "bash", "dir"}} {
+		if output, err := Run(rootName, args...); err == nil {
+			t.Fatalf("Root invocation with %v should have failed, but got: %s", args, output)
+		}
+	}
+}
+
+// TestGenDocsFlags checks app long descriptions and flags get documented
+func TestGenDocsFlags(t *testing.T) {
+	flags := flag.NewFlagSet("flagged", flag.ExitOnError)
+	flags.String("name", "world", "`who` to greet")
+	flags.Bool("v", false, "louder")
+	flagged := &App{Name: "flagged", Short: "Greets", Long: "Greets someone.\n\n.Politely.", Flags: flags, Main: func() {}}
+	apps["flagged"] = flagged
+	defer delete(apps, "flagged")
+	expected := map[string]string{
+		"man": ".TH FLAGGED 1 \"\" \"flagged\"\n.SH NAME\nflagged \\- Greets\n.SH SYNOPSIS\n" +
+			".B flagged\n[flags] [args...]\n.br\n.B root flagged\n[flags] [args...]\n" +
+			".SH DESCRIPTION\nGreets someone.\n.PP\n\\&.Politely.\n.SH OPTIONS\n" +
+			".TP\n.B \\-name \\fIwho\\fR\nwho to greet (default world)\n.TP\n.B \\-v\nlouder\n" +
+			".SH SEE ALSO\n.BR root (1)\n",
+		"markdown": "# flagged\n\nGreets\n\n## Synopsis\n\n```\nflagged [flags] [args...]\n" +
+			"root flagged [flags] [args...]\n```\n\n## Description\n\nGreets someone.\n\n.Politely.\n\n" +
+			"## Flags\n\n- `-name who`: who to greet (default `world`)\n- `-v`: louder\n\n" +
+			"## See also\n\n- [root](root.md)\n",
+	}
+	for format, page := range expected {
+		dir := t.TempDir()
+		if err := writeDocs("root", format, dir, flag.NewFlagSet("root", flag.ContinueOnError)); err != nil {
+			t.Fatalf("Gen docs %s failed: %v", format, err)
+		}
+		ext := map[string]string{"man": ".1", "markdown": ".md"}[format]
+		written, err := os.ReadFile(filepath.Join(dir, "flagged"+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(written) != page {
+			t.Fatalf("Expected %s page:\n%s\nbut got:\n%s", format, page, written)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type renameFileFunc func(oldpath, newpath string) error
+
+// renameFile substitution allows unit tests to make Apply fail halfway
+var renameFile renameFileFunc = os.Rename
+
+// ApplyError is returned when Apply fails, after all changes already done were rolled back
+type ApplyError struct {
+	Err          error    // Err is the failure that aborted Apply
+	Log          []string // Log lists every step done on the filesystem, including those rolling back
+	RollbackErrs []error  // RollbackErrs are the errors found while rolling back, if any
+}
+
+// Error describes the failure followed by the log of everything done
+func (err *ApplyError) Error() string {
+	buf := bytes.NewBufferString("")
+	if err.RollbackErrs == nil {
+		fmt.Fprintf(buf, "Apply failed and was rolled back: %v", err.Err)
+	} else {
+		fmt.Fprintf(buf, "Apply failed and could NOT be fully rolled back: %v\n%v", err.Err,
+			errors.Join(err.RollbackErrs...))
+	}
+	for _, step := range err.Log {
+		fmt.Fprintf(buf, "\n %s", step)
+	}
+	return buf.String()
+}
+
+// Unwrap returns the failure that aborted Apply
+func (err *ApplyError) Unwrap() error {
+	return err.Err
+}
+
+// transaction records the filesystem steps done by Apply, and how to undo them
+type transaction struct {
+	log  []string
+	undo []undoStep
+	dirs []string // dirs created
+}
+
+type undoStep struct {
+	step string
+	fn   func() error
+}
+
+// Apply sources changes on the filesystem, all or nothing.
+//
+// All new contents are first staged into temporary files alongside their targets,
+// creating any required directories. Then, each existing file is backed up and replaced (or removed)
+// by renaming, and only when all changes are done the backups get deleted, alongside any directory left
+// empty by removed files, as long as it was created by Apply or recorded as created by a manifest.
+//
+// Returns nil if all changes where applied. If something went wrong, all changes are rolled back
+// and an *ApplyError is returned, listing what happened.
+func (srcs *Sources) Apply() error {
+	tx := &transaction{}
+	filenames := srcs.Filenames()
+	staged := make(map[string]string)
+	for _, filename := range filenames {
+		src := srcs.srcs[filename]
+		if src == nil {
+			continue
+		}
+		if err := tx.mkdirAll(filepath.Dir(filename)); err != nil {
+			return tx.rollback(err)
+		}
+		temp, err := tx.stage(filename, src)
+		if err != nil {
+			return tx.rollback(err)
+		}
+		staged[filename] = temp
+	}
+	backups := []string{}
+	for _, filename := range filenames {
+		backup, err := tx.backup(filename)
+		if err != nil {
+			return tx.rollback(err)
+		}
+		if backup != "" {
+			backups = append(backups, backup)
+		}
+		if temp, ok := staged[filename]; ok {
+			if err := tx.replace(temp, filename); err != nil {
+				return tx.rollback(err)
+			}
+		}
+	}
+	var errs []error
+	for _, backup := range backups {
+		if err := os.Remove(backup); err != nil {
+			errs = append(errs, fmt.Errorf("Changes applied, but backup %s could not be removed: %v", backup, err))
+		}
+	}
+	for _, dir := range tx.dirs {
+		srcs.removableDirs[dir] = true
+	}
+	for _, filename := range filenames {
+		if srcs.srcs[filename] == nil {
+			removeEmptyDirs(filepath.Dir(filename), srcs.removableDirs)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeEmptyDirs removes dir and its parents as long as they are empty and removable
+func removeEmptyDirs(dir string, removable map[string]bool) {
+	for removable[filepath.Clean(dir)] && os.Remove(dir) == nil {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// done logs a step, alongside the function to undo it, if any
+func (tx *transaction) done(step string, undo func() error) {
+	tx.log = append(tx.log, step)
+	if undo != nil {
+		tx.undo = append(tx.undo, undoStep{step, undo})
+	}
+}
+
+// rollback undoes all steps done in reverse order, returning the resulting ApplyError
+func (tx *transaction) rollback(cause error) *ApplyError {
+	err := &ApplyError{Err: cause}
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if undoErr := tx.undo[i].fn(); undoErr != nil {
+			err.RollbackErrs = append(err.RollbackErrs, undoErr)
+			tx.log = append(tx.log, fmt.Sprintf("FAILED to roll back: %s", tx.undo[i].step))
+		} else {
+			tx.log = append(tx.log, fmt.Sprintf("rolled back: %s", tx.undo[i].step))
+		}
+	}
+	err.Log = tx.log
+	return err
+}
+
+// mkdirAll creates dir and any missing parents, one by one so each can be undone
+func (tx *transaction) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := tx.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	tx.done(fmt.Sprintf("created directory %s", dir), func() error { return os.Remove(dir) })
+	tx.dirs = append(tx.dirs, filepath.Clean(dir))
+	return nil
+}
+
+// stage writes src into a new temporary file alongside filename, with the same permissions if it exists
+func (tx *transaction) stage(filename string, src []byte) (string, error) {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".bigbin-*")
+	if err != nil {
+		return "", err
+	}
+	temp := file.Name()
+	tx.done(fmt.Sprintf("staged %s into %s", filename, temp), func() error {
+		if err := os.Remove(temp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+	_, err = file.Write(src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp, mode)
+	}
+	return temp, err
+}
+
+// backup moves the existing filename out of the way into a temporary file alongside it.
+// Returns an empty backup filename if there was nothing to backup.
+func (tx *transaction) backup(filename string) (string, error) {
+	if _, err := os.Lstat(filename); os.IsNotExist(err) {
+		return "", nil
+	}
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".backup-*")
+	if err != nil {
+		return "", err
+	}
+	backup := file.Name()
+	file.Close()
+	if err := renameFile(filename, backup); err != nil {
+		os.Remove(backup)
+		return "", err
+	}
+	tx.done(fmt.Sprintf("moved %s to backup %s", filename, backup), func() error { return renameFile(backup, filename) })
+	return backup, nil
+}
+
+// replace moves the staged temp file into filename
+func (tx *transaction) replace(temp, filename string) error {
+	if err := renameFile(temp, filename); err != nil {
+		return err
+	}
+	tx.done(fmt.Sprintf("replaced %s", filename), func() error { return os.Remove(filename) })
+	return nil
+}
@@ -0,0 +1,215 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BuildOptions tell Build where to put the binaries and what to pass through to go build
+type BuildOptions struct {
+	OutDir      string // OutDir is the directory to write the binaries to
+	StandAlones bool   // StandAlones also builds each app standalone binary
+	LDFlags     string // LDFlags are passed as go build -ldflags, if not empty
+	Tags        string // Tags are passed as go build -tags, if not empty
+	TrimPath    bool   // TrimPath passes go build -trimpath
+
+	// Platforms to cross compile for, each into its own {OutDir}/{os}_{arch} directory, along with a
+	// {OutDir}/SHA256SUMS file. If empty, binaries are built for the host right into OutDir
+	Platforms []Platform
+}
+
+// ChecksumsFilename is the file listing the SHA256 checksums of the binaries cross compiled by Build,
+// in the sha256sum format
+const ChecksumsFilename = "SHA256SUMS"
+
+// Platform is a GOOS/GOARCH pair to build for
+type Platform struct {
+	OS, Arch string
+}
+
+// String returns the platform as "{os}/{arch}"
+func (p Platform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+// ParsePlatforms parses a comma separated list of "{os}/{arch}" platforms, such as "linux/amd64,linux/arm64"
+func ParsePlatforms(list string) ([]Platform, error) {
+	platforms := []Platform{}
+	for _, item := range strings.Split(list, ",") {
+		goos, goarch, ok := strings.Cut(strings.TrimSpace(item), "/")
+		if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+			return nil, fmt.Errorf("Platform %q is not os/arch", item)
+		}
+		platforms = append(platforms, Platform{OS: goos, Arch: goarch})
+	}
+	return platforms, nil
+}
+
+// Artifact is a binary built by Build
+type Artifact struct {
+	Platform Platform // Platform the binary was cross compiled for, if any
+	Path     string   // Path of the binary
+	Size     int64    // Size of the binary, in bytes
+	SHA256   string   // SHA256 checksum of the binary, hex encoded
+}
+
+type goBuildFunc func(env []string, args ...string) error
+
+// goBuild substitution allows unit tests to test Build without running the go tool
+var goBuild goBuildFunc = defaultGoBuild
+
+// Build builds the bigBins generated into srcs, and their apps standalone binaries if so asked, into
+// opts.OutDir or its platform subdirectories. Big binaries are named after their directory and standalones
+// after their app.
+//
+// The filesystem is left untouched: the generated Go sources are written to a temporary directory instead
+// and laid over the original ones with go build -overlay.
+func (srcs *Sources) Build(bigBins []BigBinary, opts BuildOptions) ([]Artifact, error) {
+	if srcs.errors != nil {
+		return nil, srcs.SingleError()
+	}
+	tmpDir, err := os.MkdirTemp("", "bigbin-build")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	overlay, err := srcs.writeBuildOverlay(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"build", "-overlay", overlay}
+	if opts.LDFlags != "" {
+		args = append(args, "-ldflags", opts.LDFlags)
+	}
+	if opts.Tags != "" {
+		args = append(args, "-tags", opts.Tags)
+	}
+	if opts.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		platforms = []Platform{{}}
+	}
+	targets, outputs := buildTargets(bigBins, opts)
+	artifacts := []Artifact{}
+	for _, platform := range platforms {
+		outDir, env, suffix := opts.OutDir, []string(nil), ""
+		if platform != (Platform{}) {
+			outDir = filepath.Join(opts.OutDir, platform.OS+"_"+platform.Arch)
+			env = []string{"GOOS=" + platform.OS, "GOARCH=" + platform.Arch}
+		}
+		if platform.OS == "windows" {
+			suffix = ".exe"
+		}
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return artifacts, err
+		}
+		for i, target := range targets {
+			output := filepath.Join(outDir, outputs[i]+suffix)
+			if err := goBuild(env, append(append([]string{}, args...), "-o", output, target)...); err != nil {
+				return artifacts, fmt.Errorf("Couldn't build %s for %s: %v", target, platform, err)
+			}
+			artifact, err := newArtifact(platform, output)
+			if err != nil {
+				return artifacts, err
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	if len(opts.Platforms) > 0 {
+		return artifacts, writeChecksums(opts.OutDir, artifacts)
+	}
+	return artifacts, nil
+}
+
+// newArtifact describes the binary built for platform at filename
+func newArtifact(platform Platform, filename string) (Artifact, error) {
+	binary, err := os.ReadFile(filename)
+	if err != nil {
+		return Artifact{}, err
+	}
+	sum := sha256.Sum256(binary)
+	return Artifact{Platform: platform, Path: filename, Size: int64(len(binary)), SHA256: hex.EncodeToString(sum[:])}, nil
+}
+
+// writeChecksums writes the ChecksumsFilename at outDir, listing artifacts relative to it
+func writeChecksums(outDir string, artifacts []Artifact) error {
+	var sums strings.Builder
+	for _, artifact := range artifacts {
+		rel, err := filepath.Rel(outDir, artifact.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", artifact.SHA256, filepath.ToSlash(rel))
+	}
+	return os.WriteFile(filepath.Join(outDir, ChecksumsFilename), []byte(sums.String()), 0644)
+}
+
+// buildTargets returns the package directories to build, as go build arguments, with their binary names.
+// Apps shared by several big binaries are built standalone just once.
+func buildTargets(bigBins []BigBinary, opts BuildOptions) (targets, outputs []string) {
+	for _, bigBin := range bigBins {
+		if bigBin.Dir != "" {
+			targets = append(targets, buildTarget(bigBin.Dir))
+			outputs = append(outputs, baseName(bigBin.Dir))
+		}
+	}
+	if opts.StandAlones {
+		for _, app := range newSources().uniqueApps(bigBins) {
+			targets = append(targets, buildTarget(filepath.Dir(standAloneFilename(app))))
+			outputs = append(outputs, appName(app))
+		}
+	}
+	return targets, outputs
+}
+
+// buildTarget turns dir into a go build package argument, as relative ones must start with "./"
+func buildTarget(dir string) string {
+	dir = filepath.Clean(dir)
+	if filepath.IsAbs(dir) || strings.HasPrefix(dir, ".") {
+		return dir
+	}
+	return "." + string(filepath.Separator) + dir
+}
+
+// writeBuildOverlay writes the Go sources within srcs into tmpDir, along with the go build -overlay JSON file
+// laying them over their filenames, returning the JSON filename
+func (srcs *Sources) writeBuildOverlay(tmpDir string) (string, error) {
+	replace := make(map[string]string)
+	for i, filename := range srcs.Filenames() {
+		src := srcs.srcs[filename]
+		if !strings.HasSuffix(filename, ".go") || src == nil {
+			continue
+		}
+		original, err := absPath(filename)
+		if err != nil {
+			return "", err
+		}
+		tmpFile := filepath.Join(tmpDir, fmt.Sprintf("%d_%s", i, filepath.Base(filename)))
+		if err := os.WriteFile(tmpFile, src, 0644); err != nil {
+			return "", err
+		}
+		replace[original] = tmpFile
+	}
+	src, err := json.MarshalIndent(overlayJSON{Replace: replace}, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	overlay := filepath.Join(tmpDir, OverlayFilename)
+	return overlay, os.WriteFile(overlay, src, 0644)
+}
+
+// defaultGoBuild runs the go tool with args and env added to the environment, forwarding its output
+func defaultGoBuild(env []string, args ...string) error {
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
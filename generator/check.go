@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+)
+
+// DriftKind classifies how a file on the filesystem drifted from its generated source
+type DriftKind int
+
+const (
+	Missing  DriftKind = iota // Missing generated file
+	Changed                   // Changed file contents, either stale or hand edited
+	Leftover                  // Leftover file that should have been removed
+)
+
+// String returns the drift kind name
+func (kind DriftKind) String() string {
+	switch kind {
+	case Missing:
+		return "missing"
+	case Changed:
+		return "changed"
+	case Leftover:
+		return "leftover"
+	}
+	return fmt.Sprintf("DriftKind(%d)", int(kind))
+}
+
+// Drift describes a file on the filesystem that does not match Sources
+type Drift struct {
+	Filename       string
+	Kind           DriftKind
+	Added, Removed int // Lines to be added and removed on the filesystem to match Sources
+}
+
+// String returns a one line summary of the drift
+func (drift Drift) String() string {
+	return fmt.Sprintf("%s: %s (+%d -%d lines)", drift.Filename, drift.Kind, drift.Added, drift.Removed)
+}
+
+// Check compares sources against the filesystem, returning the drifts found sorted by filename,
+// or nil if the filesystem already matches what Apply would write.
+//
+// Returns an error if any file could not be read.
+func (srcs *Sources) Check() ([]Drift, error) {
+	var drifts []Drift
+	for _, filename := range srcs.Filenames() {
+		src := srcs.srcs[filename]
+		current, err := readFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		exists := err == nil
+		switch {
+		case src == nil && exists:
+			drifts = append(drifts, Drift{Filename: filename, Kind: Leftover, Removed: len(splitLines(current))})
+		case src != nil && !exists:
+			drifts = append(drifts, Drift{Filename: filename, Kind: Missing, Added: len(splitLines(src))})
+		case src != nil && string(current) != string(src):
+			added, removed := countChanges(diffLines(splitLines(current), splitLines(src)))
+			drifts = append(drifts, Drift{Filename: filename, Kind: Changed, Added: added, Removed: removed})
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,593 @@
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config describes the big binaries to generate, as read from a bigbin.yaml file such as:
+//
+//	bigbins:
+//	  - to: ./cmd/all
+//	    apps: [./cmd/...]
+//	    exclude: [experimental]
+//	    overrides:
+//	      ./cmd/x:
+//	        name: xtool
+//	        aliases: [xt]
+//	        short: Does x things
+//	        long: |
+//	          Does x things, and explains how at length.
+//
+//	          Over several paragraphs.
+//
+// Only a subset of YAML is supported: block maps and lists, flow lists and maps, plain, single or double
+// quoted scalars, literal and folded block scalars and comments. Anchors, multi line plain or quoted
+// scalars, block scalar indentation indicators or several documents are not.
+type Config struct {
+	BigBins []BigBinConfig
+}
+
+// BigBinConfig describes a big binary and its apps
+type BigBinConfig struct {
+	To        string         // To is the directory in where to generate the big binary main
+	Apps      []string       // Apps lists main directories or "dir/..." patterns, see Discover
+	Exclude   []string       // Exclude lists app names or directories to leave out, see Discover
+	Overrides map[string]App // Overrides app registration defaults, by directory
+}
+
+// ReadConfig reads the big binaries configuration at filename
+func ReadConfig(filename string) (*Config, error) {
+	src, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseConfig(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return config, nil
+}
+
+// Resolve discovers the apps of the big binary, with their overrides applied.
+// Overrides for directories that are not an app of the big binary fail.
+func (bb *BigBinConfig) Resolve() (BigBinary, error) {
+	mainDirs, err := Discover(bb.To, bb.Exclude, bb.Apps...)
+	if err != nil {
+		return BigBinary{}, err
+	}
+	overrides := make(map[string]App)
+	for dir, app := range bb.Overrides {
+		overrides[filepath.Clean(dir)] = app
+	}
+	apps := []App{}
+	for _, dir := range mainDirs {
+		app := overrides[filepath.Clean(dir)]
+		delete(overrides, filepath.Clean(dir))
+		app.Dir = dir
+		apps = append(apps, app)
+	}
+	if len(overrides) > 0 {
+		unmatched := []string{}
+		for dir := range overrides {
+			unmatched = append(unmatched, dir)
+		}
+		sort.Strings(unmatched)
+		return BigBinary{}, fmt.Errorf("Overrides for %s match no app of %s", strings.Join(unmatched, ", "), bb.To)
+	}
+	return BigBinary{Dir: bb.To, Apps: apps}, nil
+}
+
+// parseConfig decodes the YAML src into a Config, failing on unknown keys or unexpected values
+func parseConfig(src string) (*Config, error) {
+	doc, err := parseYAML(src)
+	if err != nil {
+		return nil, err
+	}
+	root, err := yamlMap(doc, "config", "bigbins")
+	if err != nil {
+		return nil, err
+	}
+	bigbins, ok := root["bigbins"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("bigbins must be a list of big binaries")
+	}
+	config := &Config{}
+	for i, node := range bigbins {
+		context := fmt.Sprintf("bigbins[%d]", i)
+		fields, err := yamlMap(node, context, "to", "apps", "exclude", "overrides")
+		if err != nil {
+			return nil, err
+		}
+		bb := BigBinConfig{Overrides: make(map[string]App)}
+		if bb.To, err = yamlString(fields["to"], context+".to"); err != nil {
+			return nil, err
+		}
+		if bb.Apps, err = yamlStrings(fields["apps"], context+".apps"); err != nil {
+			return nil, err
+		}
+		if len(bb.Apps) == 0 {
+			return nil, fmt.Errorf("%s.apps must list at least one app", context)
+		}
+		if bb.Exclude, err = yamlStrings(fields["exclude"], context+".exclude"); err != nil {
+			return nil, err
+		}
+		overrides, err := yamlMap(fields["overrides"], context+".overrides")
+		if err != nil {
+			return nil, err
+		}
+		for dir, node := range overrides {
+			app, err := parseOverride(node, context+".overrides."+dir)
+			if err != nil {
+				return nil, err
+			}
+			app.Dir = dir
+			bb.Overrides[dir] = app
+		}
+		config.BigBins = append(config.BigBins, bb)
+	}
+	return config, nil
+}
+
+// parseOverride decodes an app registration override
+func parseOverride(node interface{}, context string) (app App, err error) {
+	fields, err := yamlMap(node, context, "name", "aliases", "short", "long")
+	if err != nil {
+		return app, err
+	}
+	if app.Name, err = yamlString(fields["name"], context+".name"); err != nil {
+		return app, err
+	}
+	if app.Aliases, err = yamlStrings(fields["aliases"], context+".aliases"); err != nil {
+		return app, err
+	}
+	if app.Short, err = yamlString(fields["short"], context+".short"); err != nil {
+		return app, err
+	}
+	app.Long, err = yamlString(fields["long"], context+".long")
+	return app, err
+}
+
+// yamlMap returns node as a map, failing if it is not, or if it has keys other than the given ones, if any.
+// A missing node is an empty map.
+func yamlMap(node interface{}, context string, keys ...string) (map[string]interface{}, error) {
+	if node == nil {
+		return map[string]interface{}{}, nil
+	}
+	fields, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map", context)
+	}
+	if len(keys) > 0 {
+		for key := range fields {
+			if !contains(keys, key) {
+				return nil, fmt.Errorf("%s: unknown key %q, expected one of %s", context, key, strings.Join(keys, ", "))
+			}
+		}
+	}
+	return fields, nil
+}
+
+// yamlString returns node as a string, failing if it is not. A missing node is an empty string.
+func yamlString(node interface{}, context string) (string, error) {
+	if node == nil {
+		return "", nil
+	}
+	s, ok := node.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", context)
+	}
+	return s, nil
+}
+
+// yamlStrings returns node as a list of strings, failing if it is not. A missing node is an empty list.
+func yamlStrings(node interface{}, context string) ([]string, error) {
+	if node == nil {
+		return nil, nil
+	}
+	list, ok := node.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list", context)
+	}
+	strs := []string{}
+	for i, item := range list {
+		s, err := yamlString(item, fmt.Sprintf("%s[%d]", context, i))
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// yamlLine is a non blank YAML line, without comments
+type yamlLine struct {
+	num    int     // line number, from 1
+	indent int     // indentation, in spaces
+	text   string  // text after the indentation
+	block  *string // block is the value of the block scalar the line ends with, if any
+}
+
+// yamlParser parses YAML lines into maps, lists or strings, nil standing for empty values
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses the supported subset of YAML, see Config
+func parseYAML(src string) (interface{}, error) {
+	p := &yamlParser{}
+	raw := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	for i := 0; i < len(raw); i++ {
+		text := strings.TrimRight(stripComment(raw[i]), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		line := yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed}
+		if header, parent, ok := blockHeader(line); ok {
+			// the block scalar takes the following blank lines or those indented deeper than its parent
+			end := i + 1
+			for ; end < len(raw); end++ {
+				content := strings.TrimRight(raw[end], " \t\r")
+				if content != "" && len(content)-len(strings.TrimLeft(content, " ")) <= parent {
+					break
+				}
+			}
+			value, err := blockScalar(header, raw[i+1:end], line.num)
+			if err != nil {
+				return nil, err
+			}
+			line.block, i = &value, end-1
+		}
+		p.lines = append(p.lines, line)
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	node, err := p.parseNode(p.lines[0].indent)
+	if err == nil && p.pos < len(p.lines) {
+		err = fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return node, err
+}
+
+// parseNode parses the block starting at the current line, if indented at least by indent
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent < indent {
+		return nil, nil
+	}
+	line := p.lines[p.pos]
+	if isListItem(line.text) {
+		return p.parseList(line.indent)
+	}
+	return p.parseMap(line.indent)
+}
+
+// parseList parses the list items at indent
+func (p *yamlParser) parseList(indent int) ([]interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isListItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+		switch {
+		case rest == "":
+			p.pos++
+			item, err := p.parseNode(indent + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		case isMapEntry(rest):
+			// the item is a map starting at this same line, where its keys are indented
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(rest), text: rest,
+				block: line.block}
+			item, err := p.parseMap(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		case line.block != nil:
+			list = append(list, *line.block)
+			p.pos++
+		default:
+			item, err := parseScalar(rest, line.num)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			p.pos++
+		}
+	}
+	return list, nil
+}
+
+// parseMap parses the map entries at indent
+func (p *yamlParser) parseMap(indent int) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isListItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		if !isMapEntry(line.text) {
+			return nil, fmt.Errorf("line %d: expected key: value but got %q", line.num, line.text)
+		}
+		key, rest := splitMapEntry(line.text)
+		key, err := unquoteScalar(key, line.num)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fields[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicated key %q", line.num, key)
+		}
+		p.pos++
+		var value interface{}
+		switch {
+		case line.block != nil:
+			value = *line.block
+		case rest != "":
+			value, err = parseScalar(rest, line.num)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isListItem(p.lines[p.pos].text):
+			value, err = p.parseList(indent)
+		default:
+			value, err = p.parseNode(indent + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+// isListItem tells whether text is a list item, as in "- item"
+func isListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isMapEntry tells whether text is a map entry, as in "key: value" or "key:"
+func isMapEntry(text string) bool {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return false
+	}
+	key, _ := splitMapEntry(text)
+	return key != ""
+}
+
+// splitMapEntry splits text into key and value at the first colon outside quotes followed by a space,
+// or returns an empty key if there is none
+func splitMapEntry(text string) (key, value string) {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case opensQuote(text, i):
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+		}
+	}
+	return "", text
+}
+
+// parseScalar parses a scalar or a flow list or map of those, as in "[a, 'b', "c"]" or "{name: x, aliases: [y]}"
+func parseScalar(text string, num int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: unterminated list %s", num, text)
+		}
+		list := []interface{}{}
+		for _, item := range splitFlow(text[1 : len(text)-1]) {
+			value, err := parseScalar(item, num)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("line %d: unterminated map %s", num, text)
+		}
+		fields := make(map[string]interface{})
+		for _, entry := range splitFlow(text[1 : len(text)-1]) {
+			key, rest := splitMapEntry(entry)
+			if key == "" {
+				return nil, fmt.Errorf("line %d: expected key: value but got %q", num, entry)
+			}
+			key, err := unquoteScalar(key, num)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := fields[key]; ok {
+				return nil, fmt.Errorf("line %d: duplicated key %q", num, key)
+			}
+			if rest == "" {
+				fields[key] = nil
+			} else if fields[key], err = parseScalar(rest, num); err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	}
+	return unquoteScalar(text, num)
+}
+
+// splitFlow splits the inside of a flow list or map at commas outside quotes or nested flow lists and maps,
+// returning the trimmed items, if any
+func splitFlow(text string) []string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	items, quote, depth, start := []string{}, byte(0), 0, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case opensQuote(text, i):
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(text[start:]))
+}
+
+// blockHeader returns the header of the block scalar line ends with, as in "key: |" or "- >-", along with
+// the indentation of its parent node, the key or list item, as its content must be indented deeper
+func blockHeader(line yamlLine) (header string, parent int, ok bool) {
+	text, parent := line.text, line.indent
+	for isListItem(text) {
+		parent = line.indent + len(line.text) - len(text)
+		text = strings.TrimLeft(text[1:], " ")
+	}
+	if isMapEntry(text) {
+		parent = line.indent + len(line.text) - len(text)
+		_, text = splitMapEntry(text)
+	}
+	if text == "" || (text[0] != '|' && text[0] != '>') {
+		return "", 0, false
+	}
+	return text, parent, true
+}
+
+// blockScalar returns the value of the literal "|" or folded ">" block scalar with the given header,
+// and chomping indicator, if any, made of the content lines
+func blockScalar(header string, lines []string, num int) (string, error) {
+	style, chomping := header[0], header[1:]
+	if chomping != "" && chomping != "-" && chomping != "+" {
+		return "", fmt.Errorf("line %d: unsupported block scalar header %s", num, header)
+	}
+	indent := -1
+	content := []string{}
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.TrimSpace(line) == "" {
+			content = append(content, "")
+			continue
+		}
+		if indent < 0 {
+			indent = len(line) - len(trimmed)
+		}
+		if len(line)-len(trimmed) < indent {
+			return "", fmt.Errorf("line %d: block scalar less indented than its first line", num+1+i)
+		}
+		content = append(content, line[indent:])
+	}
+	trailing := 0
+	for len(content) > 0 && content[len(content)-1] == "" {
+		content, trailing = content[:len(content)-1], trailing+1
+	}
+	var value string
+	if style == '|' {
+		value = strings.Join(content, "\n")
+	} else {
+		value = foldLines(content)
+	}
+	switch {
+	case len(content) == 0 || chomping == "-":
+	case chomping == "+":
+		value += strings.Repeat("\n", trailing+1)
+	default:
+		value += "\n"
+	}
+	return value, nil
+}
+
+// foldLines joins the lines of a folded block scalar: single line breaks between lines become spaces, while
+// blank lines become line breaks, and those around more indented lines are kept
+func foldLines(lines []string) string {
+	var folded strings.Builder
+	previous, blanks := "", 0
+	for i, line := range lines {
+		if line == "" {
+			blanks++
+			continue
+		}
+		switch {
+		case i == blanks:
+			folded.WriteString(strings.Repeat("\n", blanks))
+		case blanks == 0 && !moreIndented(previous) && !moreIndented(line):
+			folded.WriteString(" ")
+		case moreIndented(previous) || moreIndented(line):
+			folded.WriteString(strings.Repeat("\n", blanks+1))
+		default:
+			folded.WriteString(strings.Repeat("\n", blanks))
+		}
+		folded.WriteString(line)
+		previous, blanks = line, 0
+	}
+	return folded.String()
+}
+
+// moreIndented tells whether a folded block scalar line is indented deeper than the block, so it is not folded
+func moreIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// unquoteScalar returns the value of a plain, single or double quoted scalar
+func unquoteScalar(text string, num int) (string, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("line %d: bad double quoted string %s", num, text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("line %d: bad single quoted string %s", num, text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*") || strings.HasPrefix(text, "|") ||
+		strings.HasPrefix(text, ">"):
+		return "", fmt.Errorf("line %d: unsupported YAML %s", num, text)
+	}
+	return text, nil
+}
+
+// stripComment removes a trailing comment from line, that is from a # at its start or after a space,
+// outside quotes
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case opensQuote(line, i):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// opensQuote tells whether text has a quote at i starting a quoted scalar, rather than within a plain one
+func opensQuote(text string, i int) bool {
+	return (text[i] == '"' || text[i] == '\'') && (i == 0 || strings.IndexByte(" \t[{,:", text[i-1]) >= 0)
+}
@@ -0,0 +1,157 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// lineOp is a line of a line based diff: kept (' '), removed ('-') or added ('+')
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits src into lines, keeping the line terminators so that a last line without one differs
+func splitLines(src []byte) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the line operations to turn a into b, using the longest common subsequence
+func diffLines(a, b []string) []lineOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	ops := make([]lineOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, lineOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, lineOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, lineOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, lineOp{'+', b[j]})
+	}
+	return ops
+}
+
+// countChanges returns how many lines are added and removed by ops
+func countChanges(ops []lineOp) (added, removed int) {
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// diffContext is the number of unchanged lines surrounding each change in unified diffs
+const diffContext = 3
+
+// writeUnifiedDiff writes the git style unified diff of path, relative to the current directory with forward
+// slashes, from old to new contents, where a nil old is a new file and a nil new is a deleted file.
+// Nothing is written if they are equal.
+func writeUnifiedDiff(buf *bytes.Buffer, path string, old, new []byte) {
+	if old != nil && new != nil && bytes.Equal(old, new) {
+		return
+	}
+	from, to := "a/"+path, "b/"+path
+	fmt.Fprintf(buf, "diff --git %s %s\n", from, to)
+	switch {
+	case old == nil:
+		fmt.Fprintf(buf, "new file mode 100644\n")
+		from = "/dev/null"
+	case new == nil:
+		fmt.Fprintf(buf, "deleted file mode 100644\n")
+		to = "/dev/null"
+	}
+	fmt.Fprintf(buf, "--- %s\n+++ %s\n", from, to)
+	writeHunks(buf, diffLines(splitLines(old), splitLines(new)))
+}
+
+// writeHunks writes ops as unified diff hunks with diffContext lines of context
+func writeHunks(buf *bytes.Buffer, ops []lineOp) {
+	// oldLines[k] and newLines[k] are the lines of each side before ops[k]
+	oldLines, newLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		oldLines[k+1], newLines[k+1] = oldLines[k], newLines[k]
+		if op.kind != '+' {
+			oldLines[k+1]++
+		}
+		if op.kind != '-' {
+			newLines[k+1]++
+		}
+	}
+	for next := 0; next < len(ops); {
+		for next < len(ops) && ops[next].kind == ' ' {
+			next++
+		}
+		if next == len(ops) {
+			return
+		}
+		start, end := max(next-diffContext, 0), next
+		for k := next; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end+1 > 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext, len(ops))
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n",
+			hunkRange(oldLines[start], oldLines[end]), hunkRange(newLines[start], newLines[end]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(buf, "%c%s", op.kind, op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				fmt.Fprintf(buf, "\n%s\n", noNewline)
+			}
+		}
+		next = end
+	}
+}
+
+// noNewline marks, as in diff and git, that the line before lacks a line terminator as the last of its file
+const noNewline = `\ No newline at end of file`
+
+// hunkRange formats the range of lines after from up to to, as expected in hunk headers
+func hunkRange(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"fmt"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// RecursiveSuffix marks a directory pattern to be walked for main packages, as in "./cmd/..."
+const RecursiveSuffix = "/..."
+
+// Discover expands patterns into the list of main directories to turn into applets:
+//
+// - A directory ending in "/..." is walked for main packages, either still a "package main" with a
+// "func main()" or already generated by a previous invocation. Directories named testdata or vendor,
+// or starting with "." or "_", are skipped as the go tool does, as well as bigBinDir and generated mains.
+//
+// - Any other directory is taken as is.
+//
+// Directories whose app name or path match any of the excludes, as in filepath.Match, are left out.
+// Directories are returned in walk order, without duplicates. A pattern matching no directory fails.
+func Discover(bigBinDir string, excludes []string, patterns ...string) ([]string, error) {
+	skipDir := ""
+	if bigBinDir != "" {
+		dir, err := absPath(bigBinDir)
+		if err != nil {
+			return nil, err
+		}
+		skipDir = dir
+	}
+	mainDirs, found := []string{}, make(map[string]bool)
+	for _, pattern := range patterns {
+		dirs := []string{pattern}
+		if pattern == "..." || strings.HasSuffix(pattern, RecursiveSuffix) {
+			root := strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/")
+			if root == "" {
+				root = "."
+			}
+			var err error
+			if dirs, err = walkMainDirs(root, skipDir); err != nil {
+				return nil, err
+			}
+		}
+		matched := false
+		for _, dir := range dirs {
+			if excluded(dir, excludes) {
+				continue
+			}
+			matched = true
+			if !found[filepath.Clean(dir)] {
+				found[filepath.Clean(dir)] = true
+				mainDirs = append(mainDirs, dir)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("Pattern %s matched no main packages", pattern)
+		}
+	}
+	return mainDirs, nil
+}
+
+// walkMainDirs lists the main package directories under root, but skipDir
+func walkMainDirs(root, skipDir string) ([]string, error) {
+	dirs := []string{}
+	err := filepath.WalkDir(root, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		name := entry.Name()
+		if dir != root && (name == "testdata" || name == "vendor" ||
+			strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		if abs, err := absPath(dir); err != nil {
+			return err
+		} else if abs == skipDir {
+			return filepath.SkipDir
+		}
+		isMain, err := isMainDir(dir)
+		if err != nil {
+			return err
+		}
+		if isMain {
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// isMainDir tells whether dir holds a main package to turn into an applet, either still a "package main"
+// with a "func main()" or already named after dir with its autoregistration file.
+// Generated files, like standalone mains, and test files are not taken into account.
+func isMainDir(dir string) (bool, error) {
+	packages, err := parseDir(token.NewFileSet(), dir)
+	if err != nil {
+		return false, fmt.Errorf("Couldn't parse directory %s: %v", dir, err)
+	}
+	packageName, _ := safePackageName(dir, packages)
+	for pkg, astpkg := range packages {
+		if _, ok := astpkg.Files[autoregisterFilename(dir, packageName)]; ok && pkg == packageName {
+			return true, nil
+		}
+		if pkg != "main" {
+			continue
+		}
+		for filename, astfile := range astpkg.Files {
+			if strings.HasSuffix(filename, "_test.go") ||
+				(astfile.Doc != nil && strings.HasPrefix(astfile.Doc.Text(), "Do NOT edit manually!")) {
+				continue
+			}
+			if findFunc(astfile, "main") != nil {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// excluded tells whether the app name or path of dir match any of the excludes patterns
+func excluded(dir string, excludes []string) bool {
+	for _, exclude := range excludes {
+		exclude = filepath.Clean(exclude)
+		if ok, _ := filepath.Match(exclude, baseName(dir)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(exclude, filepath.Clean(dir)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -16,28 +16,42 @@ Benefits:
 
 Source code changes automated by this Generate(bigBinDir, mainDirs...) are, for all "mainDirs":
 
-1) Rename in all *.go files the package name from main to the last name of that directory path
+1) Rename in all *.go files the package name from main to the last name of that directory path, made a valid
+identifier ("foo-bar" becomes "foo_bar"). Go keywords, "main" and names of imported packages get "app" suffixed
+("type" becomes "typeapp", "fmt" importing fmt becomes "fmtapp"), and the renaming is reported. The app name, "{appname}" below, stays the last name of the directory
+path unless overridden, as in "genbigbin --name ./cmd/x=xtool"
 
-2) Rename "func main()" to "func Main()"
+2) Rename "func main()" to "func Main()", or to "func Main(_ []string) int" if main ends with "os.Exit(status)",
+which becomes "return status" so that bigbin.Main can exit with that status after the app returns
 
-3) Add an autoregistration file with a init() that registers this package Main to be invocable by the big binary:
+3) Move flags registered at package level ("var v = flag.Bool(...)" or within init funcs) into the app own flag set,
+"BigBinFlags.Bool(...)", installed as flag.CommandLine only when the app runs, so that apps' flags do not collide
 
-	package {appname}
+4) Rename "func init()" funcs to "func bigbinInit{N}()", called in order by the app "BigBinInit()" initializer
+that bigbin.Main runs only for the selected app, so the big binary does not pay all apps' initialization on startup.
+Package level vars whose initialization calls functions can't be deferred that way, so they are reported instead,
+as are the init funcs of files with build constraints. Test files are left alone, but for their package name
+
+5) Add an autoregistration file with a init() that registers this package Main to be invocable by the big binary:
+
+	package {pkgname}
 
 	import "github.com/josvazg/bigbin"
 
 	func init() {
-		bigbin.Add("{appname}", Main)
+		bigbin.Register(bigbin.App{Name: "{appname}", Short: "{package doc synopsis}", Main: Main})
 	}
 
-4) A standalone main will be generated at {directory=appname}/{appname} wich code such as:
+The whole package doc comment is registered as Long too, when it says more than its synopsis.
+
+6) A standalone main will be generated at {directory}/{appname} wich code such as:
 
 	package main
 
-	import "{directory import path}"
+	import {pkgname} "{directory import path}"
 
 	func main() {
-		{appname}.Main()
+		{pkgname}.Main()
 	}
 
 Also, if "bigBinDir" is not empty, the bigbin main is created at "bigBinDir" with code such as:
@@ -65,5 +79,12 @@ generation.
 Use Sources.Apply() to enforce the changes to the file system.
 
 - Restore() does the exact opposite to Generate() to help users undo their changes if needed.
+Generate() records in a ".bigbin.json" manifest, at each directory, the files it created or modified, their
+hashes and original names, so that Restore() undoes exactly that, refusing to delete files modified afterwards.
+
+- Overlay() is a non destructive alternative to Generate() that leaves the mains untouched and places the
+transformed copies, along with the big binary main, in a shadow tree at "bigBinDir"/_overlay instead, to be
+built with "go build -overlay bigBinDir/overlay.json" from the module root, as the overlay paths are relative to it.
+RestoreOverlay() removes them.
 */
 package generator
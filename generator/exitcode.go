@@ -0,0 +1,207 @@
+package generator
+
+import (
+	"go/ast"
+	"go/token"
+	pathpkg "path"
+	"strconv"
+)
+
+// toMainE modifies astfile so that a 'func Main()' ending with 'os.Exit(status)' becomes
+// 'func Main(_ []string) int' ending with 'return status', so it can be registered as a bigbin.MainFuncE.
+// If the os package is no longer used after that, its import is removed.
+//
+// Mains with any other return statement are left alone, as they would need to choose an exit status.
+// Returns true if the file contains an exit status aware Main, either converted here or beforehand.
+func toMainE(fileset *token.FileSet, astfile *ast.File) bool {
+	fndecl := findFunc(astfile, "Main")
+	if fndecl == nil || fndecl.Body == nil {
+		return false
+	}
+	if isMainE(fndecl) {
+		return true
+	}
+	if fndecl.Type.Params.NumFields() != 0 || fndecl.Type.Results.NumFields() != 0 {
+		return false
+	}
+	osName := importName(astfile, "os")
+	stmts := fndecl.Body.List
+	if osName == "" || len(stmts) == 0 || hasReturns(fndecl.Body) {
+		return false
+	}
+	status := exitStatus(stmts[len(stmts)-1], osName)
+	if status == nil {
+		return false
+	}
+	stmts[len(stmts)-1] = &ast.ReturnStmt{Return: stmts[len(stmts)-1].Pos(), Results: []ast.Expr{status}}
+	opening := fndecl.Type.Params.Opening // positions keep the printer from splitting the parameter list
+	fndecl.Type.Params.List = []*ast.Field{{
+		Names: []*ast.Ident{{NamePos: opening + 1, Name: "_"}},
+		Type:  &ast.ArrayType{Lbrack: opening + 3, Elt: &ast.Ident{NamePos: opening + 5, Name: "string"}},
+	}}
+	fndecl.Type.Results = &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("int")}}}
+	if !usesPackage(astfile, osName) {
+		removeImport(fileset, astfile, "os")
+	}
+	return true
+}
+
+// fromMainE undoes toMainE, turning 'func Main(_ []string) int' ending with 'return status'
+// back into 'func Main()' ending with 'os.Exit(status)', importing os if required.
+func fromMainE(fileset *token.FileSet, astfile *ast.File) {
+	fndecl := findFunc(astfile, "Main")
+	if fndecl == nil || fndecl.Body == nil || !isMainE(fndecl) {
+		return
+	}
+	stmts := fndecl.Body.List
+	ret, ok := stmts[len(stmts)-1].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return
+	}
+	osName := importName(astfile, "os")
+	if osName == "" {
+		osName = "os"
+		addImport(fileset, astfile, "os")
+	}
+	stmts[len(stmts)-1] = &ast.ExprStmt{X: &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: ast.NewIdent(osName), Sel: ast.NewIdent("Exit")},
+		Args: ret.Results,
+	}}
+	fndecl.Type.Params.List = nil
+	fndecl.Type.Results = nil
+}
+
+// findFunc returns the top level func declaration named name, if any
+func findFunc(astfile *ast.File, name string) *ast.FuncDecl {
+	for _, decl := range astfile.Decls {
+		if fndecl, ok := decl.(*ast.FuncDecl); ok && fndecl.Recv == nil && fndecl.Name.Name == name {
+			return fndecl
+		}
+	}
+	return nil
+}
+
+// isMainE tells whether fndecl has the 'func(_ []string) int' signature generated by toMainE
+func isMainE(fndecl *ast.FuncDecl) bool {
+	params, results := fndecl.Type.Params.List, fndecl.Type.Results
+	if len(params) != 1 || len(params[0].Names) != 1 || params[0].Names[0].Name != "_" ||
+		results == nil || len(results.List) != 1 || len(fndecl.Body.List) == 0 {
+		return false
+	}
+	array, ok := params[0].Type.(*ast.ArrayType)
+	return ok && array.Len == nil && isIdent(array.Elt, "string") && isIdent(results.List[0].Type, "int")
+}
+
+// exitStatus returns the status expression of stmt if it is an '{osName}.Exit(status)' call, or nil otherwise
+func exitStatus(stmt ast.Stmt, osName string) ast.Expr {
+	exprStmt, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return nil
+	}
+	call, ok := exprStmt.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return nil
+	}
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !isIdent(selector.X, osName) || selector.Sel.Name != "Exit" {
+		return nil
+	}
+	return call.Args[0]
+}
+
+// hasReturns tells whether body contains any return statement, not counting those in function literals
+func hasReturns(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// usesPackage tells whether astfile refers to any '{name}.X' selector
+func usesPackage(astfile *ast.File, name string) bool {
+	found := false
+	ast.Inspect(astfile, func(node ast.Node) bool {
+		if selector, ok := node.(*ast.SelectorExpr); ok && isIdent(selector.X, name) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// importName returns the name path is imported as in astfile, or an empty string if it is not imported
+func importName(astfile *ast.File, path string) string {
+	for _, spec := range astfile.Imports {
+		if importPath, err := strconv.Unquote(spec.Path.Value); err != nil || importPath != path {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return pathpkg.Base(path)
+	}
+	return ""
+}
+
+// removeImport removes path from the imports of astfile, along with its import declaration if left empty
+func removeImport(fileset *token.FileSet, astfile *ast.File, path string) {
+	quoted := strconv.Quote(path)
+	decls := astfile.Decls[:0]
+	for _, decl := range astfile.Decls {
+		if gendecl, ok := decl.(*ast.GenDecl); ok && gendecl.Tok == token.IMPORT {
+			specs := gendecl.Specs[:0]
+			for _, spec := range gendecl.Specs {
+				if spec.(*ast.ImportSpec).Path.Value != quoted {
+					specs = append(specs, spec)
+				} else if line := fileset.Position(spec.Pos()).Line; gendecl.Lparen.IsValid() && line > 1 {
+					fileset.File(spec.Pos()).MergeLine(line - 1) // avoid leaving an empty line behind
+				}
+			}
+			gendecl.Specs = specs
+			if len(specs) == 0 {
+				continue
+			}
+		}
+		decls = append(decls, decl)
+	}
+	astfile.Decls = decls
+	imports := astfile.Imports[:0]
+	for _, spec := range astfile.Imports {
+		if spec.Path.Value != quoted {
+			imports = append(imports, spec)
+		}
+	}
+	astfile.Imports = imports
+}
+
+// addImport adds path to the first import declaration of astfile, creating one if there is none
+func addImport(fileset *token.FileSet, astfile *ast.File, path string) {
+	spec := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)}}
+	astfile.Imports = append(astfile.Imports, spec)
+	for _, decl := range astfile.Decls {
+		if gendecl, ok := decl.(*ast.GenDecl); ok && gendecl.Tok == token.IMPORT {
+			if !gendecl.Lparen.IsValid() {
+				gendecl.Lparen, gendecl.Rparen = gendecl.Pos(), gendecl.End()
+			}
+			spec.Path.ValuePos = gendecl.Rparen - 1
+			gendecl.Specs = append(gendecl.Specs, spec)
+			ast.SortImports(fileset, astfile)
+			return
+		}
+	}
+	gendecl := &ast.GenDecl{TokPos: astfile.Name.End(), Tok: token.IMPORT, Specs: []ast.Spec{spec}}
+	astfile.Decls = append([]ast.Decl{gendecl}, astfile.Decls...)
+}
+
+// isIdent tells whether expr is the identifier name
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
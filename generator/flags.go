@@ -0,0 +1,76 @@
+package generator
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// FlagSetVar is the per app flag set declared by the autoregistration code,
+// holding the flags the app registers at package level
+const FlagSetVar = "BigBinFlags"
+
+// flagRegistrations are the flag package functions registering flags into flag.CommandLine
+var flagRegistrations = map[string]bool{
+	"Bool": true, "BoolVar": true, "BoolFunc": true, "Duration": true, "DurationVar": true,
+	"Float64": true, "Float64Var": true, "Func": true, "Int": true, "IntVar": true, "Int64": true, "Int64Var": true,
+	"String": true, "StringVar": true, "TextVar": true, "Uint": true, "UintVar": true, "Uint64": true,
+	"Uint64Var": true, "Var": true,
+}
+
+// toFlagSet modifies astfile so that flags registered at package level, that is in var declarations or
+// init funcs, go to the app's own flag set instead of the global flag.CommandLine shared by all apps:
+//
+// "flag.Bool(...)" -> "BigBinFlags.Bool(...)"
+//
+// If the flag package is no longer used after that, its import is removed.
+// Returns true if the file registers flags into the app flag set, either converted here or beforehand.
+func toFlagSet(fileset *token.FileSet, astfile *ast.File) bool {
+	flagName := importName(astfile, "flag")
+	if flagName != "" {
+		renamePackageLevelCalls(astfile, flagName, FlagSetVar)
+		if !usesPackage(astfile, flagName) {
+			removeImport(fileset, astfile, "flag")
+		}
+	}
+	return usesPackage(astfile, FlagSetVar)
+}
+
+// fromFlagSet undoes toFlagSet, registering package level flags back into flag.CommandLine,
+// importing the flag package if required.
+func fromFlagSet(fileset *token.FileSet, astfile *ast.File) {
+	if !usesPackage(astfile, FlagSetVar) {
+		return
+	}
+	flagName := importName(astfile, "flag")
+	if flagName == "" {
+		flagName = "flag"
+		addImport(fileset, astfile, "flag")
+	}
+	renamePackageLevelCalls(astfile, FlagSetVar, flagName)
+}
+
+// renamePackageLevelCalls renames "{from}.X(...)" into "{to}.X(...)" for every flag registration function X
+// called within var declarations or init funcs of astfile
+func renamePackageLevelCalls(astfile *ast.File, from, to string) {
+	rename := func(node ast.Node) bool {
+		if call, ok := node.(*ast.CallExpr); ok {
+			if selector, ok := call.Fun.(*ast.SelectorExpr); ok &&
+				isIdent(selector.X, from) && flagRegistrations[selector.Sel.Name] {
+				selector.X.(*ast.Ident).Name = to
+			}
+		}
+		return true
+	}
+	for _, decl := range astfile.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Tok == token.VAR {
+				ast.Inspect(decl, rename)
+			}
+		case *ast.FuncDecl:
+			if isInitFunc(decl) {
+				ast.Inspect(decl.Body, rename)
+			}
+		}
+	}
+}
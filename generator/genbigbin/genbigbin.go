@@ -4,6 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/josvazg/bigbin/generator"
 )
@@ -15,37 +19,350 @@ func dieOnError(err error) {
 	}
 }
 
+// listFlag is a repeatable flag also accepting comma separated values
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, strings.Split(value, ",")...)
+	return nil
+}
+
+// options tell what to do with each big binary
+type options struct {
+	apply, restore, overlay, check, diff bool
+}
+
 func main() {
-	var bigBinDir string
-	var apply, restore bool
+	var bigBinDir, configFile string
+	var excludes, names listFlag
+	var opts options
+	var lint bool
+	// "genbigbin build" generates in memory to build the binaries, instead of generating into the filesystem,
+	// "genbigbin size" does so to compare the big binaries with their standalones
+	// and "genbigbin image" to package them as container images
+	var buildOpts generator.BuildOptions
+	var imageOpts generator.ImageOptions
+	var platforms, imageFile, imagePlatform string
+	var top int
+	build := len(os.Args) > 1 && os.Args[1] == "build"
+	size := len(os.Args) > 1 && os.Args[1] == "size"
+	image := len(os.Args) > 1 && os.Args[1] == "image"
+	if build || size || image {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	if build {
+		flag.StringVar(&buildOpts.OutDir, "o", "", "Directory to write the built binaries to "+
+			"(bin by default, or dist when cross compiling)")
+		flag.StringVar(&platforms, "platforms", "", "Comma separated os/arch list to cross compile for, "+
+			"each into its own {os}_{arch} directory with a SHA256SUMS file")
+		flag.BoolVar(&buildOpts.StandAlones, "standalone", false, "Also build each app standalone binary "+
+			"(false by default)")
+	}
+	if size {
+		flag.IntVar(&top, "top", 20, "Number of biggest packages to report")
+	}
+	if image {
+		flag.StringVar(&imageFile, "o", "", "OCI image layout tarball to write ({bigbin}.tar by default)")
+		flag.StringVar(&imageOpts.Path, "path", "", "Path of the big binary within the image, "+
+			"with the app symlinks next to it (/usr/local/bin/{bigbin} by default)")
+		flag.StringVar(&imageOpts.Entrypoint, "entrypoint", "", "App name to run by default "+
+			"(the big binary itself by default)")
+		flag.StringVar(&imagePlatform, "platform", "linux/"+runtime.GOARCH, "os/arch to build the image for")
+		flag.StringVar(&imageOpts.Tag, "tag", "", "Image reference ({bigbin}:latest by default)")
+	}
+	if build || size || image {
+		flag.StringVar(&buildOpts.LDFlags, "ldflags", "", "Passed through to go build -ldflags")
+		flag.StringVar(&buildOpts.Tags, "tags", "", "Passed through to go build -tags")
+		flag.BoolVar(&buildOpts.TrimPath, "trimpath", false, "Passed through to go build -trimpath (false by default)")
+	}
 	flag.StringVar(&bigBinDir, "to", "", "Directory in where to generate the big binary main "+
 		"(by default is empty and does not create a big binary main)")
-	flag.BoolVar(&apply, "apply", false, "Apply changes to the filesystem (false by default)")
-	flag.BoolVar(&restore, "restore", false, "Restore files to before the big binary changes intead (false by default)")
+	flag.StringVar(&configFile, "config", "", "YAML file describing the big binaries to generate, "+
+		"instead of -to, -exclude, -name and main dirs")
+	flag.BoolVar(&opts.apply, "apply", false, "Apply changes to the filesystem (false by default)")
+	flag.BoolVar(&opts.restore, "restore", false, "Restore files to before the big binary changes intead (false by default)")
+	flag.BoolVar(&opts.overlay, "overlay", false, "Leave main packages untouched and generate transformed copies "+
+		"to build the big binary with 'go build -overlay' instead (false by default)")
+	flag.BoolVar(&opts.check, "check", false, "Check the filesystem already matches the generated code, "+
+		"failing with a summary of each drifted file otherwise (false by default)")
+	flag.BoolVar(&opts.diff, "diff", false, "Print unified diffs from the filesystem to the generated code, "+
+		"instead of full file contents (false by default)")
+	flag.BoolVar(&lint, "lint", false, "Only report conflicts between apps, such as http handlers "+
+		"or expvar keys registered at init by several apps, failing if any is found (false by default)")
+	flag.Var(&excludes, "exclude", "App name or directory, or a filepath.Match pattern of those, "+
+		"to leave out of the main dirs, repeatable or comma separated")
+	flag.Var(&names, "name", "App name override as mainDir=name, to invoke the app as other than "+
+		"its directory name, repeatable or comma separated")
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage:")
-		fmt.Fprintln(os.Stderr, os.Args[0], "[flags]", "mainDir1 [mainDir2...]")
+		fmt.Fprintln(os.Stderr, os.Args[0], "[flags]", "mainDir1|dir/... [mainDir2...]")
+		fmt.Fprintln(os.Stderr, os.Args[0], "[flags]", "-config bigbin.yaml")
+		fmt.Fprintln(os.Stderr, os.Args[0], "build [flags]", "mainDir1|dir/... [mainDir2...] | -config bigbin.yaml")
+		fmt.Fprintln(os.Stderr, os.Args[0], "size [flags]", "mainDir1|dir/... [mainDir2...] | -config bigbin.yaml")
+		fmt.Fprintln(os.Stderr, os.Args[0], "image [flags]", "mainDir1|dir/... [mainDir2...] | -config bigbin.yaml")
 		fmt.Fprintln(os.Stderr, "\nFlags:")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	mainDirs := flag.Args()
-	if mainDirs == nil || len(mainDirs) == 0 {
+	if platforms != "" {
+		var err error
+		buildOpts.Platforms, err = generator.ParsePlatforms(platforms)
+		dieOnError(err)
+	}
+	if image {
+		platform, err := generator.ParsePlatforms(imagePlatform)
+		dieOnError(err)
+		if len(platform) != 1 {
+			fmt.Fprintln(os.Stderr, "image builds for a single -platform")
+			os.Exit(2)
+		}
+		imageOpts.Platform = platform[0]
+	}
+	if buildOpts.OutDir == "" {
+		buildOpts.OutDir = "bin"
+		if len(buildOpts.Platforms) > 0 {
+			buildOpts.OutDir = "dist"
+		}
+	}
+	var bigBins []generator.BigBinConfig
+	switch {
+	case (build || size || image) && (opts != options{} || lint):
+		fmt.Fprintln(os.Stderr, "build, size and image can't be combined with -apply, -restore, -overlay, -check, -diff or -lint")
+		os.Exit(2)
+	case configFile != "" && (flag.NArg() > 0 || bigBinDir != "" || len(excludes) > 0 || len(names) > 0):
+		fmt.Fprintln(os.Stderr, "-config can't be combined with -to, -exclude, -name or main dirs")
+		os.Exit(2)
+	case configFile != "":
+		config, err := generator.ReadConfig(configFile)
+		dieOnError(err)
+		bigBins = config.BigBins
+	case flag.NArg() == 0:
 		flag.Usage()
 		os.Exit(2)
+	default:
+		overrides := make(map[string]generator.App)
+		for _, override := range names {
+			dir, name, ok := strings.Cut(override, "=")
+			if !ok || dir == "" || name == "" {
+				fmt.Fprintf(os.Stderr, "-name %q is not mainDir=name\n", override)
+				os.Exit(2)
+			}
+			overrides[dir] = generator.App{Dir: dir, Name: name}
+		}
+		bigBins = []generator.BigBinConfig{{To: bigBinDir, Apps: flag.Args(), Exclude: excludes, Overrides: overrides}}
+	}
+	resolved := []generator.BigBinary{}
+	for _, bb := range bigBins {
+		bigBin, err := bb.Resolve()
+		dieOnError(err)
+		resolved = append(resolved, bigBin)
+	}
+	failed := false
+	switch {
+	case build:
+		runBuild(resolved, buildOpts)
+	case size:
+		for _, bigBin := range resolved {
+			runSize(bigBin, buildOpts, top)
+		}
+	case image:
+		if len(resolved) > 1 && (imageFile != "" || imageOpts.Path != "" || imageOpts.Tag != "") {
+			fmt.Fprintln(os.Stderr, "-o, -path and -tag can't be shared by several big binaries")
+			os.Exit(2)
+		}
+		for _, bigBin := range resolved {
+			runImage(bigBin, buildOpts, imageOpts, imageFile)
+		}
+	case lint:
+		for _, bigBin := range resolved {
+			failed = runLint(bigBin.Apps) || failed
+		}
+	case opts.overlay:
+		// overlays are generated within each big binary directory, so they share nothing
+		for _, bigBin := range resolved {
+			failed = run([]generator.BigBinary{bigBin}, opts) || failed
+		}
+	default:
+		failed = run(resolved, opts)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runLint reports conflicts between apps, returning true if any was found
+func runLint(apps []generator.App) bool {
+	conflicts, err := generator.Lint(apps...)
+	dieOnError(err)
+	for _, conflict := range conflicts {
+		fmt.Println(conflict)
 	}
+	if len(conflicts) > 0 {
+		fmt.Printf("%d conflicts found\n", len(conflicts))
+		return true
+	}
+	return false
+}
+
+// run generates or restores the big binaries, then checks, applies, diffs or prints the result.
+// Overlays are run one big binary at a time. Returns true if the check failed.
+func run(bigBins []generator.BigBinary, opts options) bool {
 	// Generate or Restore, depending on restore flag
 	var sources *generator.Sources
-	if !restore {
-		sources = generator.Generate(bigBinDir, mainDirs...)
-	} else {
-		sources = generator.Restore(bigBinDir, mainDirs...)
+	switch {
+	case opts.overlay && !opts.restore:
+		sources = generator.OverlayApps(bigBins[0].Dir, bigBins[0].Apps...)
+	case opts.overlay:
+		sources = generator.RestoreOverlay(bigBins[0].Dir, dirs(bigBins[0].Apps)...)
+	case !opts.restore:
+		sources = generator.GenerateBigBins(bigBins...)
+	default:
+		sources = generator.RestoreBigBins(bigBins...)
 	}
 	dieOnError(sources.SingleError())
-	// if code generation was successful, apply or print
-	if apply {
+	for _, note := range sources.Notes() {
+		fmt.Fprintln(os.Stderr, note)
+	}
+	// if code generation was successful, check, apply, diff or print
+	if opts.check {
+		drifts, err := sources.Check()
+		dieOnError(err)
+		for _, drift := range drifts {
+			fmt.Println(drift)
+		}
+		if drifts != nil {
+			fmt.Printf("%d files do not match the generated code\n", len(drifts))
+			return true
+		}
+	} else if opts.apply {
 		dieOnError(sources.Apply())
+		if opts.overlay && !opts.restore {
+			fmt.Printf("Build the big binary with:\n go build -overlay %s ./%s\n",
+				filepath.Join(bigBins[0].Dir, generator.OverlayFilename), filepath.Clean(bigBins[0].Dir))
+		}
+	} else if opts.diff {
+		patch, err := sources.Diff()
+		dieOnError(err)
+		fmt.Print(patch)
 	} else {
 		fmt.Print(sources.String())
 	}
+	return false
+}
+
+// runBuild generates the big binaries in memory and builds them, reporting each binary size,
+// and platform and checksum when cross compiling
+func runBuild(bigBins []generator.BigBinary, opts generator.BuildOptions) {
+	sources := generator.GenerateBigBins(bigBins...)
+	dieOnError(sources.SingleError())
+	for _, note := range sources.Notes() {
+		fmt.Fprintln(os.Stderr, note)
+	}
+	artifacts, err := sources.Build(bigBins, opts)
+	dieOnError(err)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(opts.Platforms) > 0 {
+		fmt.Fprintln(tw, "PLATFORM\tBINARY\tSIZE\tSHA256")
+	}
+	for _, artifact := range artifacts {
+		if len(opts.Platforms) > 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", artifact.Platform, artifact.Path, humanSize(artifact.Size),
+				artifact.SHA256[:12])
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\n", artifact.Path, humanSize(artifact.Size))
+		}
+	}
+	tw.Flush()
+	if len(opts.Platforms) > 0 {
+		fmt.Printf("%d binaries for %d platforms, checksums at %s\n", len(artifacts), len(opts.Platforms),
+			filepath.Join(opts.OutDir, generator.ChecksumsFilename))
+	}
+}
+
+// runSize builds the big binary and its standalones, reporting how much the big binary saves
+// and the top biggest packages within it
+func runSize(bigBin generator.BigBinary, opts generator.BuildOptions, top int) {
+	if bigBin.Dir == "" {
+		fmt.Fprintln(os.Stderr, "size needs a big binary, set with -to")
+		os.Exit(2)
+	}
+	sources := generator.GenerateBigBins(bigBin)
+	dieOnError(sources.SingleError())
+	report, err := sources.Size(bigBin, opts)
+	dieOnError(err)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STANDALONE\tSIZE")
+	for _, standAlone := range report.StandAlones {
+		fmt.Fprintf(tw, "%s\t%s\n", filepath.Base(standAlone.Path), humanSize(standAlone.Size))
+	}
+	fmt.Fprintf(tw, "total\t%s\n", humanSize(report.Sum()))
+	fmt.Fprintf(tw, "%s (big binary)\t%s\n", filepath.Base(report.BigBinary.Path), humanSize(report.BigBinary.Size))
+	fmt.Fprintf(tw, "savings\t%s\t(%.1f%%)\n", humanSize(report.Sum()-report.BigBinary.Size), report.Savings())
+	fmt.Fprintln(tw, "\nPACKAGE\tSIZE\tSHARE")
+	for i, pkg := range report.Packages {
+		if i == top {
+			fmt.Fprintf(tw, "... %d more\t\t\n", len(report.Packages)-top)
+			break
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\n", pkg.Package, humanSize(pkg.Size),
+			100*float64(pkg.Size)/float64(report.BigBinary.Size))
+	}
+	tw.Flush()
+}
+
+// runImage builds the big binary into an OCI image layout tarball, at imageFile or named after the big binary
+func runImage(bigBin generator.BigBinary, buildOpts generator.BuildOptions, opts generator.ImageOptions,
+	imageFile string) {
+	if bigBin.Dir == "" {
+		fmt.Fprintln(os.Stderr, "image needs a big binary, set with -to")
+		os.Exit(2)
+	}
+	name := filepath.Base(bigBin.Dir)
+	if abs, err := filepath.Abs(bigBin.Dir); err == nil {
+		name = filepath.Base(abs)
+	}
+	if imageFile == "" {
+		imageFile = name + ".tar"
+	}
+	if opts.Path == "" {
+		opts.Path = "/usr/local/bin/" + name
+	}
+	if opts.Tag == "" {
+		opts.Tag = name + ":latest"
+	}
+	sources := generator.GenerateBigBins(bigBin)
+	dieOnError(sources.SingleError())
+	for _, note := range sources.Notes() {
+		fmt.Fprintln(os.Stderr, note)
+	}
+	dieOnError(sources.Image(bigBin, buildOpts, opts, imageFile))
+	fmt.Printf("%s %s image for %s at %s, load it with: docker load -i %s\n", opts.Tag, opts.Platform, opts.Path,
+		imageFile, imageFile)
+}
+
+// humanSize formats size in bytes with a binary unit, as in "1.5 MiB"
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// dirs returns the main directories of apps
+func dirs(apps []generator.App) []string {
+	dirs := []string{}
+	for _, app := range apps {
+		dirs = append(dirs, app.Dir)
+	}
+	return dirs
 }
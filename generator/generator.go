@@ -2,14 +2,18 @@ package generator
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
+	"go/doc"
 	"go/format"
 	"go/parser"
 	"go/token"
-	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -21,19 +25,39 @@ const (
 	AutoRegister = Header + `Autoregister code
 package %s
 
-import "github.com/josvazg/bigbin"
+import %s
+
+%s
 
 func init() {
-	bigbin.AddApp("%s", Main)
+	bigbin.Register(bigbin.App{Name: %q, Short: %q, %s})
+}`
+
+	AutoRegisterFlagSet = `// ` + FlagSetVar + ` holds the package level flags of this app, to become flag.CommandLine when it runs
+var ` + FlagSetVar + ` = flag.NewFlagSet(%q, flag.ExitOnError)`
+
+	AutoRegisterInit = `
+
+// ` + InitFunc + ` runs the app init funcs, only once the app is selected to run
+func ` + InitFunc + `() {
+	%s
+}`
+
+	TestHook = Header + `Test hook
+package %s
+
+// init runs the app init funcs for its tests, as no big binary runs them then
+func init() {
+	` + InitFunc + `()
 }`
 
 	StandAlone = Header + `Standalone main for %s
 package main 
 
-import "%s"
+import %s
 
 func main() {
-    %s.Main()
+    %s
 }
 `
 
@@ -53,6 +77,8 @@ func main() {
 
 	AutoregisterSuffix = "_autoregister.go"
 
+	TestHookSuffix = "_bigbin_test.go"
+
 	SourcesSeparator = "\n=================================================\n"
 
 	RemovedFile = ""
@@ -63,6 +89,43 @@ func main() {
 type Sources struct {
 	srcs   map[string][]byte
 	errors []error
+	notes  []string
+
+	// removableDirs may be removed once left empty, as Apply created them or a manifest recorded so
+	removableDirs map[string]bool
+}
+
+// App is a main package to turn into an applet, along with how to register it
+type App struct {
+	Dir     string   // Dir of the main package
+	Name    string   // Name to invoke the app as, the directory name by default
+	Aliases []string // Aliases are alternative names to invoke the app
+	Short   string   // Short description of the app, the package doc synopsis by default
+	Long    string   // Long description of the app, the package doc comment by default
+}
+
+// Apps returns the Apps for mainDirs, registered with the defaults
+func Apps(mainDirs ...string) []App {
+	apps := make([]App, 0, len(mainDirs))
+	for _, dir := range mainDirs {
+		apps = append(apps, App{Dir: dir})
+	}
+	return apps
+}
+
+// dirs returns the main directories of apps
+func dirs(apps []App) []string {
+	dirs := make([]string, 0, len(apps))
+	for _, app := range apps {
+		dirs = append(dirs, app.Dir)
+	}
+	return dirs
+}
+
+// BigBinary is a big binary main to generate at Dir, if not empty, bundling Apps
+type BigBinary struct {
+	Dir  string
+	Apps []App
 }
 
 // Generate takes the following inputs:
@@ -76,14 +139,33 @@ type Sources struct {
 // Generate is idempotent, it supports being called upon files already modified by a previous invocation.
 // It always generates exactly the same set of sources code changes (in absence of errors).
 func Generate(bigBinDir string, mainDirs ...string) *Sources {
+	return GenerateApps(bigBinDir, Apps(mainDirs...)...)
+}
+
+// GenerateApps is Generate for apps registered with other than the default names or descriptions
+func GenerateApps(bigBinDir string, apps ...App) *Sources {
+	return GenerateBigBins(BigBinary{Dir: bigBinDir, Apps: apps})
+}
+
+// GenerateBigBins is Generate for several big binaries at once, each bundling its own subset of apps.
+// Apps shared by several big binaries are generated just once, so they must be registered the same way by all.
+func GenerateBigBins(bigBins ...BigBinary) *Sources {
 	srcs := newSources()
-	for _, dir := range mainDirs {
-		srcs.addFixedMains(dir)
-		srcs.addAutoregistration(dir)
-		srcs.addStandAlone(dir)
+	for _, app := range srcs.uniqueApps(bigBins) {
+		dir := app.Dir
+		info := srcs.addFixedMains(dir)
+		srcs.addAutoregistration(app, info)
+		srcs.addStandAlone(app, info)
+		srcs.addTestHook(dir, info)
+		created := []string{autoregisterFilename(dir, info.packageName), standAloneFilename(app),
+			testHookFilename(dir, info.packageName)}
+		srcs.addManifest(dir, created, srcs.fixedMainFilenames(dir, created), info.originals)
 	}
-	if bigBinDir != "" {
-		srcs.addBigBinMain(bigBinDir, mainDirs)
+	for _, bigBin := range bigBins {
+		if bigBin.Dir != "" {
+			srcs.addBigBinMain(bigBin.Dir, dirs(bigBin.Apps))
+			srcs.addManifest(bigBin.Dir, []string{bigBinFilename(bigBin.Dir)}, nil, nil)
+		}
 	}
 	return srcs
 }
@@ -91,31 +173,68 @@ func Generate(bigBinDir string, mainDirs ...string) *Sources {
 // Restore does the opposite to Generate, creates the sources so that code can return to its state
 // previous to a call to Generate:
 //
-// - Files created by Generate will be marked for removal
+// - Files created by Generate will be marked for removal, as recorded by the manifest files
+// written by Generate, unless they were modified afterwards
 //
-// - Modifications by Generate will be reverted
+// - Modifications by Generate will be reverted, back to the original names recorded by the manifests
 //
 // - BigBird is only removed is bigBinDir is non empty
 //
+// For directories without manifest, generated files are guessed by name, but only removed if they start
+// with the generated code Header.
+//
 // Restore, like Generate, is also idempotent.
 func Restore(bigBinDir string, mainDirs ...string) *Sources {
+	return RestoreBigBins(BigBinary{Dir: bigBinDir, Apps: Apps(mainDirs...)})
+}
+
+// RestoreBigBins is Restore for several big binaries at once, restoring shared apps just once
+func RestoreBigBins(bigBins ...BigBinary) *Sources {
 	srcs := newSources()
-	for _, dir := range mainDirs {
-		srcs.addRestoredMains(dir)
-		srcs.removeAutoregistration(dir)
-		srcs.removeStandAlone(dir)
+	for _, app := range srcs.uniqueApps(bigBins) {
+		dir := app.Dir
+		manifest := srcs.readManifest(dir)
+		packageName := srcs.addRestoredMains(dir, manifest)
+		srcs.removeCreated(dir, manifest, autoregisterFilename(dir, packageName), standAloneFilename(app))
 	}
-	if bigBinDir != "" {
-		srcs.removeBigBinMain(bigBinDir, mainDirs)
+	for _, bigBin := range bigBins {
+		if bigBin.Dir != "" {
+			srcs.removeCreated(bigBin.Dir, srcs.readManifest(bigBin.Dir), bigBinFilename(bigBin.Dir))
+		}
 	}
 	return srcs
 }
 
-// Dump Sources to a string
+// uniqueApps returns the apps of all bigBins, without duplicates, in order of appearance.
+// It fails if a big binary directory is repeated, or if a shared app is registered differently.
+func (srcs *Sources) uniqueApps(bigBins []BigBinary) []App {
+	apps, seen, bigBinDirs := []App{}, make(map[string]App), make(map[string]bool)
+	for _, bigBin := range bigBins {
+		if bigBin.Dir != "" {
+			if bigBinDirs[filepath.Clean(bigBin.Dir)] {
+				srcs.fail("Big binary %s is generated more than once", bigBin.Dir)
+			}
+			bigBinDirs[filepath.Clean(bigBin.Dir)] = true
+		}
+		for _, app := range bigBin.Apps {
+			dir := filepath.Clean(app.Dir)
+			if first, ok := seen[dir]; !ok {
+				seen[dir] = app
+				apps = append(apps, app)
+			} else if first.Name != app.Name || first.Short != app.Short || first.Long != app.Long ||
+				strings.Join(first.Aliases, ",") != strings.Join(app.Aliases, ",") {
+				srcs.fail("App %s is registered differently by several big binaries", app.Dir)
+			}
+		}
+	}
+	return apps
+}
+
+// Dump Sources to a string, sorted by filename
 func (srcs *Sources) String() string {
 	buf := bytes.NewBufferString("")
-	for filename, src := range srcs.srcs {
-		if src != nil {
+	for _, filename := range srcs.Filenames() {
+		if src := srcs.srcs[filename]; src != nil {
 			fmt.Fprintf(buf, "%s:%s%s%s\n\n", filename, SourcesSeparator, string(src), SourcesSeparator)
 		} else {
 			fmt.Fprintf(buf, "%s: to be removed\n\n", filename)
@@ -124,11 +243,51 @@ func (srcs *Sources) String() string {
 	return buf.String()
 }
 
+// Diff returns the unified diffs, sorted by filename, from the current filesystem contents to the sources,
+// ready to be applied by "git apply" or "patch -p1" from the current directory.
+// Files already matching the sources are skipped.
+//
+// Returns an error if any file could not be read, or is not within the current directory.
+func (srcs *Sources) Diff() (string, error) {
+	buf := bytes.NewBufferString("")
+	root, err := absPath(".")
+	if err != nil {
+		return "", err
+	}
+	for _, filename := range srcs.Filenames() {
+		current, err := readFile(filename)
+		if os.IsNotExist(err) {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if current == nil && srcs.srcs[filename] == nil {
+			continue
+		}
+		path, err := rootRelative(root, filename)
+		if err == nil && (path == ".." || strings.HasPrefix(path, "../")) {
+			err = fmt.Errorf("not within the current directory %s", root)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Can't diff %s: %v", filename, err)
+		}
+		writeUnifiedDiff(buf, path, current, srcs.srcs[filename])
+	}
+	return buf.String(), nil
+}
+
 // Errors returns the errors registered within Sources, or returns nil if there where no errors
 func (srcs *Sources) Errors() []error {
 	return srcs.errors
 }
 
+// Notes returns the remarks on the generated sources that are not errors, like the package level
+// initializations that could not be deferred until their app runs
+func (srcs *Sources) Notes() []string {
+	return srcs.notes
+}
+
 // SingleError joins all Sources errors into a single one, or returns nil if there where no errors
 func (srcs *Sources) SingleError() error {
 	if srcs.errors == nil {
@@ -145,37 +304,7 @@ func (srcs *Sources) SingleError() error {
 			fmt.Fprintf(buf, "%v", err)
 		}
 	}
-	return fmt.Errorf(buf.String())
-}
-
-// Apply sources changes on the filesystem.
-//
-// Modified files are overwrittten right away.
-// Newly generated files are created, alongside with required directories
-//
-// Returns nil if all changes where applied or an (IO related) error if something went wrong
-func (srcs *Sources) Apply() error {
-	for filename, src := range srcs.srcs {
-		if src != nil {
-			dir := filepath.Dir(filename)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return err
-			}
-			file, err := os.Create(filename)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			if _, err := io.WriteString(file, string(src)); err != nil {
-				return err
-			}
-		} else {
-			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
-				return err
-			}
-		}
-	}
-	return nil
+	return errors.New(buf.String())
 }
 
 // Source returns the source code string for the givne filename
@@ -183,12 +312,13 @@ func (srcs *Sources) Source(filename string) string {
 	return string(srcs.srcs[filename])
 }
 
-// Filenames enumerate all sources files generated
+// Filenames enumerate all sources files generated, sorted
 func (srcs *Sources) Filenames() []string {
 	filenames := make([]string, 0, len(srcs.srcs))
 	for filename, _ := range srcs.srcs {
 		filenames = append(filenames, filename)
 	}
+	sort.Strings(filenames)
 	return filenames
 }
 
@@ -204,34 +334,74 @@ var absPath absPathFunc = defaultAbsPath
 
 // newSources generates a new sources type for processing and generating code
 func newSources() *Sources {
-	return &Sources{srcs: make(map[string][]byte)}
+	return &Sources{srcs: make(map[string][]byte), removableDirs: make(map[string]bool)}
 }
 
 // addFixedMains will generate code to fix files in dir so that:
 //
 // "package main" -> "package {pkgname}" & "func main()" -> "func Main()"
 //
+// Also, if main ends with "os.Exit(status)", it becomes "func Main(_ []string) int" returning status instead,
+// so that it gets registered as a bigbin.MainFuncE.
+//
+// And flags registered at package level are moved into the app own flag set, see toFlagSet.
+//
+// Init funcs are renamed so that they only run when the app does, see toLazyInits, and package level vars
+// whose initialization can't be deferred that way are noted, as are the init funcs of constrained files.
+//
+// Test files, external test packages included, just get their package renamed, as they are not part
+// of the big binary.
+//
 // The generated sources are added srcs.
 //
 // It will register an error if something goes wrong, like a package is not named as expected,
 // some package was missing any func Main or func mains or the generated code failed validation.
-func (srcs *Sources) addFixedMains(dir string) {
+func (srcs *Sources) addFixedMains(dir string) (info mainInfo) {
 	fileset := token.NewFileSet()
 	packages, err := parseDir(fileset, dir)
 	if err != nil {
 		srcs.fail("Couldn't parse directory %s:%v", dir, err)
 		return
 	}
-	packageName := packageName(dir)
+	packageName, reason := safePackageName(dir, packages)
+	info.packageName = packageName
+	if reason != "" {
+		srcs.notes = append(srcs.notes, fmt.Sprintf("%s: %s, package %s is used instead", dir, reason, packageName))
+	}
+	info.originals = make(map[string]manifestEntry)
 	for pkg, astpkg := range packages {
+		if strings.HasSuffix(pkg, "_test") {
+			srcs.addRenamedTests(fileset, astpkg, packageName+"_test", info.originals)
+			continue
+		}
 		if pkg != "main" && pkg != packageName {
 			srcs.fail("%s expected to be 'main' or already %s but was %s!", dir, packageName, pkg)
 			return
 		}
 		mainFound := false
-		for filename, astfile := range astpkg.Files {
+		for _, filename := range sortedFilenames(astpkg.Files) {
+			if filename == autoregisterFilename(dir, packageName) || filename == testHookFilename(dir, packageName) {
+				continue
+			}
+			astfile := astpkg.Files[filename]
+			if astfile.Name.Name == "main" {
+				info.originals[filename] = manifestEntry{OriginalPackage: "main", OriginalFunc: mainFuncName(astfile)}
+			}
 			astfile.Name = ast.NewIdent(packageName)
-			mainFound = mainFound || renameFunc(astfile, "main", "Main")
+			info.tests = info.tests || strings.HasSuffix(filename, "_test.go")
+			if !strings.HasSuffix(filename, "_test.go") {
+				if renameFunc(astfile, "main", "Main") {
+					mainFound = true
+					info.mainE = toMainE(fileset, astfile)
+				}
+				info.flags = toFlagSet(fileset, astfile) || info.flags
+				if constrained(filename, astfile) {
+					srcs.notes = append(srcs.notes, constrainedInits(fileset, astfile)...)
+				} else {
+					info.inits = append(info.inits, toLazyInits(astfile, len(info.inits))...)
+				}
+				srcs.notes = append(srcs.notes, eagerInits(fileset, astfile)...)
+			}
 			if src, err := gofmt(fileset, astfile); err != nil {
 				srcs.fail("Couldn't gofmt astfile: %v", err)
 				return
@@ -244,92 +414,225 @@ func (srcs *Sources) addFixedMains(dir string) {
 			return
 		}
 	}
+	return info
+}
+
+// addRenamedTests renames the files of the external test package astpkg to name,
+// recording the original names of those not renamed beforehand
+func (srcs *Sources) addRenamedTests(fileset *token.FileSet, astpkg *ast.Package, name string,
+	originals map[string]manifestEntry) {
+	for filename, astfile := range astpkg.Files {
+		if astfile.Name.Name == "main_test" {
+			originals[filename] = manifestEntry{OriginalPackage: "main_test"}
+		}
+		astfile.Name = ast.NewIdent(name)
+		srcs.addFormatted(fileset, filename, astfile)
+	}
+}
+
+// addFormatted adds the gofmted astfile as the source for filename
+func (srcs *Sources) addFormatted(fileset *token.FileSet, filename string, astfile *ast.File) {
+	if src, err := gofmt(fileset, astfile); err != nil {
+		srcs.fail("Couldn't gofmt astfile: %v", err)
+	} else {
+		srcs.srcs[filename] = src
+	}
 }
 
 // addRestoredMains will generate code to undo the changes by addFixedMains:
 //
 // "package {pkgname}" -> "package main" & "func Main()" -> "func main()"
-func (srcs *Sources) addRestoredMains(dir string) {
+//
+// Unless the manifest m records other original names for each file.
+//
+// Returns the package name addFixedMains used for dir.
+func (srcs *Sources) addRestoredMains(dir string, m *manifest) (packageName string) {
 	fileset := token.NewFileSet()
 	packages, err := parseDir(fileset, dir)
 	if err != nil {
 		srcs.fail("Couldn't parse directory %s:%v", dir, err)
 		return
 	}
-	packageName := packageName(dir)
+	packageName, _ = safePackageName(dir, packages)
 	for pkg, astpkg := range packages {
+		if strings.HasSuffix(pkg, "_test") {
+			for filename, astfile := range astpkg.Files {
+				originalPackage, _ := m.original(relativeTo(dir, filename))
+				astfile.Name = ast.NewIdent(strings.TrimSuffix(originalPackage, "_test") + "_test")
+				srcs.addFormatted(fileset, filename, astfile)
+			}
+			continue
+		}
 		if pkg != "main" && pkg != packageName {
 			srcs.fail("%s expected to be alredy 'main' or %s but was %s!", dir, packageName, pkg)
-			return
+			return packageName
 		}
 		mainFound := false
 		for filename, astfile := range astpkg.Files {
-			astfile.Name = ast.NewIdent("main")
-			mainFound = mainFound || renameFunc(astfile, "Main", "main")
+			originalPackage, originalFunc := m.original(relativeTo(dir, filename))
+			astfile.Name = ast.NewIdent(originalPackage)
+			fromMainE(fileset, astfile)
+			fromFlagSet(fileset, astfile)
+			fromLazyInits(astfile)
+			mainFound = renameFunc(astfile, "Main", originalFunc) || mainFound
 			if src, err := gofmt(fileset, astfile); err != nil {
 				srcs.fail("Couldn't gofmt astfile: %v", err)
-				return
+				return packageName
 			} else {
 				srcs.srcs[filename] = src
 			}
 		}
 		if !mainFound {
 			srcs.fail("Package %s is missing any func main or Main!", packageName)
-			return
+			return packageName
 		}
 	}
+	return packageName
 }
 
-// addAutoregistration generates an autoregistration init in the given directory package
-func (srcs *Sources) addAutoregistration(dir string) {
-	packageName := packageName(dir)
-	autoregisterFilename := fmt.Sprintf("%s_autoregister.go", packageName)
-	if src, err := compose(AutoRegister, packageName, filepath.Base(dir)); err != nil {
+// mainInfo tells what addFixedMains found out about a main package
+type mainInfo struct {
+	packageName string                   // name the main package is renamed to, see safePackageName
+	mainE       bool                     // Main returns its exit status, as a bigbin.MainFuncE
+	flags       bool                     // package level flags were moved into the app flag set
+	inits       []string                 // init funcs renamed to be called from InitFunc, in order
+	tests       bool                     // the package has test files, that need the renamed init funcs to run
+	originals   map[string]manifestEntry // original names of the files not modified beforehand, by filename
+}
+
+// fixedMainFilenames lists the sources generated for dir itself, but those in created
+func (srcs *Sources) fixedMainFilenames(dir string, created []string) []string {
+	filenames := []string{}
+	for _, filename := range srcs.Filenames() {
+		if filepath.Dir(filename) == filepath.Clean(dir) && !contains(created, filename) {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames
+}
+
+// autoregisterFilename returns the filename of the autoregistration init in the given directory package
+func autoregisterFilename(dir, packageName string) string {
+	return filepath.Join(dir, packageName+AutoregisterSuffix)
+}
+
+// testHookFilename returns the filename of the test hook in the given directory package
+func testHookFilename(dir, packageName string) string {
+	return filepath.Join(dir, packageName+TestHookSuffix)
+}
+
+// standAloneFilename returns the filename of the stand alone main of app, in a subpackage named as the app
+// so that go build names the binary after it
+func standAloneFilename(app App) string {
+	return filepath.Join(app.Dir, appName(app), "main.go")
+}
+
+// bigBinFilename returns the filename of the BigBinary main at outdir
+func bigBinFilename(outdir string) string {
+	return filepath.Join(outdir, "main.go")
+}
+
+// addAutoregistration generates an autoregistration init in the app directory package
+func (srcs *Sources) addAutoregistration(app App, info mainInfo) {
+	dir, packageName := app.Dir, info.packageName
+	imports, decls, fields := `"github.com/josvazg/bigbin"`, "", "Main: Main"
+	if info.mainE {
+		fields = "MainE: Main"
+	}
+	if len(app.Aliases) > 0 {
+		aliases := make([]string, 0, len(app.Aliases))
+		for _, alias := range app.Aliases {
+			aliases = append(aliases, strconv.Quote(alias))
+		}
+		fields = "Aliases: []string{" + strings.Join(aliases, ", ") + "}, " + fields
+	}
+	name, short, long := appName(app), app.Short, app.Long
+	if short == "" || long == "" {
+		synopsis, text := packageDoc(dir)
+		if short == "" {
+			short = synopsis
+		}
+		if long == "" && text != synopsis { // a doc comment saying more than its synopsis
+			long = text
+		}
+	}
+	if long != "" {
+		fields = "Long: " + strconv.Quote(long) + ", " + fields
+	}
+	if info.flags {
+		imports = "(\n\"flag\"\n\n\"github.com/josvazg/bigbin\"\n)"
+		decls = fmt.Sprintf(AutoRegisterFlagSet, name)
+		fields += ", Flags: " + FlagSetVar
+	}
+	if len(info.inits) > 0 {
+		decls += fmt.Sprintf(AutoRegisterInit, strings.Join(info.inits, "()\n")+"()")
+		fields += ", Init: " + InitFunc
+	}
+	if src, err := compose(AutoRegister, packageName, imports, decls, name, short, fields); err != nil {
 		srcs.fail("Couldn't process & gofmt source: %v", err)
 		return
 	} else {
-		srcs.srcs[filepath.Join(dir, autoregisterFilename)] = src
+		srcs.srcs[autoregisterFilename(dir, packageName)] = src
 	}
 }
 
-// removeAutoregistration marks an autoregistration init for deletion in the given directory package
-func (srcs *Sources) removeAutoregistration(dir string) {
-	packageName := packageName(dir)
-	autoregisterFilename := fmt.Sprintf("%s_autoregister.go", packageName)
-	srcs.srcs[filepath.Join(dir, autoregisterFilename)] = nil
+// addTestHook generates a test file running the app init funcs, when renamed by addFixedMains,
+// so that the app tests still get them run as before
+func (srcs *Sources) addTestHook(dir string, info mainInfo) {
+	if !info.tests || len(info.inits) == 0 {
+		return
+	}
+	if src, err := compose(TestHook, info.packageName); err != nil {
+		srcs.fail("Couldn't process & gofmt source: %v", err)
+	} else {
+		srcs.srcs[testHookFilename(dir, info.packageName)] = src
+	}
 }
 
-// addStandAlone adds a stand alone main invocation as a subpackage at dir
-func (srcs *Sources) addStandAlone(dir string) {
-	packageName := packageName(dir)
+// addStandAlone adds a stand alone main invocation of app as its subpackage
+func (srcs *Sources) addStandAlone(app App, info mainInfo) {
+	dir, packageName := app.Dir, info.packageName
 	packagePath, err := pkgpath(dir)
 	if err != nil {
 		srcs.fail("Couldn't get package path for %s: %v", dir, err)
 		return
 	}
-	mainFilename := filepath.Join(dir, packageName, "main.go")
-	if src, err := compose(StandAlone, packagePath, packagePath, packageName); err != nil {
+	imports, body := []string{}, packageName+".Main()"
+	if info.mainE {
+		imports = append(imports, `"os"`)
+		body = fmt.Sprintf("os.Exit(%s.Main(os.Args[1:]))", packageName)
+	}
+	if len(info.inits) > 0 {
+		body = fmt.Sprintf("%s.%s()\n%s", packageName, InitFunc, body)
+	}
+	importDecl := strconv.Quote(packagePath)
+	if packageName != path.Base(packagePath) {
+		importDecl = packageName + " " + importDecl
+	}
+	if info.flags {
+		// the app flag set takes the library flags too, as it does when run by the big binary
+		importDecl = `"github.com/josvazg/bigbin"` + "\n" + importDecl
+		body = fmt.Sprintf("bigbin.UseFlags(%s.%s)\n%s", packageName, FlagSetVar, body)
+	}
+	if len(imports) > 0 || info.flags {
+		sort.Strings(imports)
+		importDecl = "(\n" + strings.Join(imports, "\n") + "\n\n" + importDecl + "\n)"
+	}
+	if src, err := compose(StandAlone, packagePath, importDecl, body); err != nil {
 		srcs.fail("Couldn't process & gofmt source: %v", err)
 		return
 	} else {
-		srcs.srcs[mainFilename] = src
+		srcs.srcs[standAloneFilename(app)] = src
 	}
 }
 
-// removeStandAlone marks for deletion a stand alone main invocation in subpackage at dir
-func (srcs *Sources) removeStandAlone(dir string) {
-	packageName := packageName(dir)
-	mainFilename := filepath.Join(dir, packageName, "main.go")
-	srcs.srcs[mainFilename] = nil
-}
-
 // addBigBinMain generates an BigBinary main the given directories' packages
 func (srcs *Sources) addBigBinMain(outdir string, dirs []string) {
-	bigbin := filepath.Join(outdir, "main.go")
-	if imports, err := toImports(dirs); err != nil {
+	bigbin := bigBinFilename(outdir)
+	if imports, err := toImports(outdir, dirs); err != nil {
 		srcs.fail("Couldn't process imports: %v", err)
 		return
-	} else if src, err := compose(BigBin, imports...); err != nil {
+	} else if src, err := compose(BigBin, imports); err != nil {
 		srcs.fail("Couldn't process & gofmt source: %v", err)
 		return
 	} else {
@@ -337,12 +640,6 @@ func (srcs *Sources) addBigBinMain(outdir string, dirs []string) {
 	}
 }
 
-// removeBigBinMain marks the BigBinaryMain for deletion
-func (srcs *Sources) removeBigBinMain(outdir string, dirs []string) {
-	bigbin := filepath.Join(outdir, "main.go")
-	srcs.srcs[bigbin] = nil
-}
-
 // fail composes and appends a new error to the registered errors, creating the list if this is if first ocurrence
 func (srcs *Sources) fail(format string, args ...interface{}) {
 	srcs.errors = append(srcs.errors, fmt.Errorf(format, args...))
@@ -359,35 +656,118 @@ func compose(src string, args ...interface{}) ([]byte, error) {
 	return gofmted, nil
 }
 
-// packageName derives a package name from the directory
-func packageName(dir string) string {
-	return filepath.Base(dir)
+// packageDoc returns the first sentence and the full text of the package doc comment of the main package
+// at dir, ignoring files generated by this package, or empty strings if there is none
+func packageDoc(dir string) (synopsis, text string) {
+	packages, err := parseDir(token.NewFileSet(), dir)
+	if err != nil {
+		return "", "" // addFixedMains reports parsing errors
+	}
+	filenames := []string{}
+	files := make(map[string]*ast.File)
+	for _, astpkg := range packages {
+		for filename, astfile := range astpkg.Files {
+			if astfile.Doc != nil && !strings.HasSuffix(filename, "_test.go") &&
+				!strings.HasPrefix(astfile.Doc.Text(), "Do NOT edit manually!") {
+				filenames = append(filenames, filename)
+				files[filename] = astfile
+			}
+		}
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		text := files[filename].Doc.Text()
+		if synopsis := new(doc.Package).Synopsis(text); synopsis != "" {
+			return synopsis, strings.TrimSpace(text)
+		}
+	}
+	return "", ""
+}
+
+// sortedFilenames returns the filenames of the parsed files, sorted as the compiler gets them
+func sortedFilenames(files map[string]*ast.File) []string {
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
 }
 
-// toImports converts the list of directories into a list of empty canonical package path imports
-func toImports(dirs []string) ([]interface{}, error) {
-	emptyImports := make([]interface{}, 0, len(dirs))
+// toImports converts the list of directories into a block of empty canonical package path imports,
+// as seen from the package at importerDir
+func toImports(importerDir string, dirs []string) (string, error) {
+	buf := bytes.NewBufferString("")
 	for _, dir := range dirs {
-		if pkgpath, err := pkgpath(dir); err != nil {
-			return nil, err
+		if pkgpath, err := importPath(importerDir, dir); err != nil {
+			return "", err
 		} else {
-			emptyImports = append(emptyImports, fmt.Sprintf("_ \"%s\"\n", pkgpath))
+			fmt.Fprintf(buf, "_ \"%s\"\n", pkgpath)
+		}
+	}
+	return buf.String(), nil
+}
+
+// importPath extracts the package path of dir as seen from the package at importerDir,
+// which only differs from pkgpath(dir) when importerDir's module replaces dir's module with a local directory
+func importPath(importerDir, dir string) (string, error) {
+	if modulesEnabled() {
+		importer, err := absPath(importerDir)
+		if err != nil {
+			return "", err
+		}
+		target, err := absPath(dir)
+		if err != nil {
+			return "", err
+		}
+		mod, err := findModule(importer)
+		if err != nil {
+			return "", err
+		}
+		if mod != nil {
+			if path, ok := mod.replacedImportPath(target); ok {
+				return path, nil
+			}
 		}
 	}
-	return emptyImports, nil
+	return pkgpath(dir)
 }
 
-// pkgpath extract the package path of the given directory
+// pkgpath extract the package path of the given directory.
+//
+// In module mode the path is derived from the nearest enclosing go.mod,
+// otherwise (or when there is no go.mod at all) dir is expected within GOPATH source dir.
 func pkgpath(dir string) (pkgpath string, err error) {
 	pkgpath, err = absPath(dir)
 	if err != nil {
 		return "", err
 	}
+	if modulesEnabled() {
+		mod, err := findModule(pkgpath)
+		if err != nil {
+			return "", err
+		}
+		if mod != nil {
+			if path, ok := mod.importPath(pkgpath); ok {
+				return path, nil
+			}
+		}
+	}
 	prefix := filepath.Join(os.Getenv("GOPATH"), "src")
 	if !strings.HasPrefix(pkgpath, prefix) {
-		return "", fmt.Errorf("%s was expected within GOPATH source dir '%s' but it is not!", pkgpath, prefix)
+		return "", fmt.Errorf("%s was expected within a go module or GOPATH source dir '%s' but it is not!", pkgpath, prefix)
+	}
+	return filepath.ToSlash(filepath.Clean(pkgpath[len(prefix)+1:])), nil
+}
+
+// mainFuncName returns "main" if astfile declares func main, or an empty string otherwise
+func mainFuncName(astfile *ast.File) string {
+	for _, decl := range astfile.Decls {
+		if fndecl, ok := decl.(*ast.FuncDecl); ok && fndecl.Recv == nil && fndecl.Name.Name == "main" {
+			return "main"
+		}
 	}
-	return filepath.Clean(pkgpath[len(prefix)+1:]), nil
+	return ""
 }
 
 // renameFunc modifies astfile with 'func {oldname}' (if present) renamed to 'func {newname}'.
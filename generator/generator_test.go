@@ -1,12 +1,22 @@
 package generator
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
 	"go/token"
+	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +29,12 @@ const (
 	ExpectedStandAloneFilename   = SampleDir + "sample/main.go"
 	ExpectedBigBinFilename       = BigBinDir + "main.go"
 
+	ExiterDir      = "src/somewhere.com/someones/exiter/"
+	ExiterFilename = ExiterDir + "exiter.go"
+
+	FlaggerDir      = "src/somewhere.com/someones/flagger/"
+	FlaggerFilename = FlaggerDir + "flagger.go"
+
 	OriginalSample = `// Sample code
 package main
 
@@ -53,7 +69,7 @@ package sample
 import "github.com/josvazg/bigbin"
 
 func init() {
-	bigbin.Add("sample", Main)
+	bigbin.Register(bigbin.App{Name: "sample", Short: "Sample code", Main: Main})
 }`
 
 	ExpectedStandAlone = Header + `Standalone main for somewhere.com/someones/sample
@@ -83,6 +99,236 @@ func main() {
 
 var sample string = OriginalSample
 
+const (
+	OriginalExiter = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var status = 2
+
+func main() {
+	if os.Getenv("VERBOSE") != "" {
+		defer fmt.Println("Exiting")
+	}
+	os.Exit(status)
+}
+`
+
+	OriginalSilentExiter = `package main
+
+import "os"
+
+func main() {
+	os.Exit(3)
+}
+`
+
+	ExpectedSilentExiter = `package exiter
+
+func Main(_ []string) int {
+	return 3
+}
+`
+
+	ExpectedGeneratedExiter = `package exiter
+
+import (
+	"fmt"
+	"os"
+)
+
+var status = 2
+
+func Main(_ []string) int {
+	if os.Getenv("VERBOSE") != "" {
+		defer fmt.Println("Exiting")
+	}
+	return status
+}
+`
+
+	ExpectedExiterAutoRegister = Header + `Autoregister code
+package exiter
+
+import "github.com/josvazg/bigbin"
+
+func init() {
+	bigbin.Register(bigbin.App{Name: "exiter", Short: "", MainE: Main})
+}`
+
+	ExpectedExiterStandAlone = Header + `Standalone main for somewhere.com/someones/exiter
+package main
+
+import (
+	"os"
+
+	"somewhere.com/someones/exiter"
+)
+
+func main() {
+	os.Exit(exiter.Main(os.Args[1:]))
+}
+`
+)
+
+var exiter string = OriginalExiter
+
+const (
+	OriginalFlagger = `package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var verbose = flag.Bool("v", false, "verbose")
+
+func main() {
+	flag.Parse()
+	fmt.Println(*verbose)
+}
+`
+
+	ExpectedGeneratedFlagger = `package flagger
+
+import (
+	"flag"
+	"fmt"
+)
+
+var verbose = BigBinFlags.Bool("v", false, "verbose")
+
+func Main() {
+	flag.Parse()
+	fmt.Println(*verbose)
+}
+`
+
+	OriginalInitFlagger = `package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var name string
+
+func init() {
+	flag.StringVar(&name, "name", "", "name")
+}
+
+func main() {
+	fmt.Println(name)
+}
+`
+
+	ExpectedGeneratedInitFlagger = `package flagger
+
+import (
+	"fmt"
+)
+
+var name string
+
+func bigbinInit0() {
+	BigBinFlags.StringVar(&name, "name", "", "name")
+}
+
+func Main() {
+	fmt.Println(name)
+}
+`
+
+	ExpectedFlaggerAutoRegister = Header + `Autoregister code
+package flagger
+
+import (
+	"flag"
+
+	"github.com/josvazg/bigbin"
+)
+
+// BigBinFlags holds the package level flags of this app, to become flag.CommandLine when it runs
+var BigBinFlags = flag.NewFlagSet("flagger", flag.ExitOnError)
+
+func init() {
+	bigbin.Register(bigbin.App{Name: "flagger", Short: "", Main: Main, Flags: BigBinFlags})
+}`
+
+	ExpectedFlaggerStandAlone = Header + `Standalone main for somewhere.com/someones/flagger
+package main
+
+import (
+	"github.com/josvazg/bigbin"
+	"somewhere.com/someones/flagger"
+)
+
+func main() {
+	bigbin.UseFlags(flagger.BigBinFlags)
+	flagger.Main()
+}
+`
+
+	ExpectedInitFlaggerAutoRegister = Header + `Autoregister code
+package flagger
+
+import (
+	"flag"
+
+	"github.com/josvazg/bigbin"
+)
+
+// BigBinFlags holds the package level flags of this app, to become flag.CommandLine when it runs
+var BigBinFlags = flag.NewFlagSet("flagger", flag.ExitOnError)
+
+// BigBinInit runs the app init funcs, only once the app is selected to run
+func BigBinInit() {
+	bigbinInit0()
+}
+
+func init() {
+	bigbin.Register(bigbin.App{Name: "flagger", Short: "", Main: Main, Flags: BigBinFlags, Init: BigBinInit})
+}`
+
+	ExpectedInitFlaggerStandAlone = Header + `Standalone main for somewhere.com/someones/flagger
+package main
+
+import (
+	"github.com/josvazg/bigbin"
+	"somewhere.com/someones/flagger"
+)
+
+func main() {
+	bigbin.UseFlags(flagger.BigBinFlags)
+	flagger.BigBinInit()
+	flagger.Main()
+}
+`
+)
+
+var flagger string = OriginalFlagger
+
+// goMods are the in memory go.mod files visible to tests
+var goMods = map[string]string{
+	"/work/tools/go.mod": `module example.com/tools
+
+go 1.21
+`,
+	"/work/tools/nested/go.mod": "module \"example.com/nested\" // nested module\n",
+	"/work/all/go.mod": `module example.com/all
+
+require example.com/tools v0.0.0
+
+replace (
+	example.com/tools => ../tools
+	example.com/remote => example.com/fork v1.0.0
+)
+`,
+}
+
 // TestGenerate validates that Generate creates proper sources
 func TestGenerate(t *testing.T) {
 	gopath := setup()
@@ -94,6 +340,11 @@ func TestGenerate(t *testing.T) {
 			t.Fatalf("Generate failed:\n%v", sources.SingleError())
 		}
 		validate(t, sources, ExpectedGeneratedSample, ExpectedAutoRegister, ExpectedStandAlone, ExpectedBigBin)
+		for _, dir := range []string{SampleDir, BigBinDir} {
+			if sources.Source(dir+ManifestFilename) == "" {
+				t.Fatalf("Missing manifest at %s", dir)
+			}
+		}
 	}
 	shutdown(gopath)
 }
@@ -113,6 +364,1057 @@ func TestRestore(t *testing.T) {
 	shutdown(gopath)
 }
 
+// TestExitStatus validates that mains ending with os.Exit become exit status aware, and are restored back
+func TestExitStatus(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	for original, expected := range map[string]string{
+		OriginalExiter:       ExpectedGeneratedExiter,
+		OriginalSilentExiter: ExpectedSilentExiter,
+	} {
+		for _, code := range []string{original, expected} {
+			exiter = code
+			sources := Generate("", ExiterDir)
+			if sources.Errors() != nil {
+				t.Fatalf("Generate failed:\n%v", sources.SingleError())
+			}
+			assertSource(t, sources, ExiterFilename, expected)
+			assertSource(t, sources, ExiterDir+"exiter_autoregister.go", ExpectedExiterAutoRegister)
+			assertSource(t, sources, ExiterDir+"exiter/main.go", ExpectedExiterStandAlone)
+			sources = Restore("", ExiterDir)
+			if sources.Errors() != nil {
+				t.Fatalf("Restore failed:\n%v", sources.SingleError())
+			}
+			assertSource(t, sources, ExiterFilename, original)
+		}
+	}
+}
+
+// TestFlagSet validates that package level flags are moved into the app flag set, and are restored back
+func TestFlagSet(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	for original, expected := range map[string][3]string{
+		OriginalFlagger: {ExpectedGeneratedFlagger, ExpectedFlaggerAutoRegister, ExpectedFlaggerStandAlone},
+		OriginalInitFlagger: {ExpectedGeneratedInitFlagger, ExpectedInitFlaggerAutoRegister,
+			ExpectedInitFlaggerStandAlone},
+	} {
+		for _, code := range []string{original, expected[0]} {
+			flagger = code
+			sources := Generate("", FlaggerDir)
+			if sources.Errors() != nil {
+				t.Fatalf("Generate failed:\n%v", sources.SingleError())
+			}
+			assertSource(t, sources, FlaggerFilename, expected[0])
+			assertSource(t, sources, FlaggerDir+"flagger_autoregister.go", expected[1])
+			assertSource(t, sources, FlaggerDir+"flagger/main.go", expected[2])
+			sources = Restore("", FlaggerDir)
+			if sources.Errors() != nil {
+				t.Fatalf("Restore failed:\n%v", sources.SingleError())
+			}
+			assertSource(t, sources, FlaggerFilename, original)
+		}
+	}
+}
+
+// TestLazyInit validates that init funcs are deferred into the app initializer, in order,
+// that package level vars that can't be deferred are noted, and that it all gets restored back
+func TestLazyInit(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	defer func() { exiter = OriginalExiter }()
+	original := `package main
+
+import (
+	"os"
+	"strings"
+)
+
+var (
+	home    = os.Getenv("HOME")
+	fields  = strings.Fields
+	handler = func() { os.Exit(1) }
+	status  int
+)
+
+func init() {
+	status = 1
+}
+
+func init() {
+	status++
+}
+
+func main() {
+	os.Exit(status)
+}
+`
+	expected := strings.NewReplacer("func main()", "func Main(_ []string) int", "os.Exit(status)", "return status",
+		"package main", "package exiter", "func init() {\n\tstatus = 1", "func bigbinInit0() {\n\tstatus = 1",
+		"func init() {\n\tstatus++", "func bigbinInit1() {\n\tstatus++").Replace(original)
+	for _, code := range []string{original, expected} {
+		exiter = code
+		sources := Generate("", ExiterDir)
+		if sources.Errors() != nil {
+			t.Fatalf("Generate failed:\n%v", sources.SingleError())
+		}
+		assertSource(t, sources, ExiterFilename, expected)
+		autoregister := sources.Source(ExiterDir + "exiter_autoregister.go")
+		if !strings.Contains(autoregister, "func BigBinInit() {\n\tbigbinInit0()\n\tbigbinInit1()\n}") ||
+			!strings.Contains(autoregister, "Init: BigBinInit") {
+			t.Fatalf("Unexpected autoregistration:\n%s", autoregister)
+		}
+		if !strings.Contains(sources.Source(ExiterDir+"exiter/main.go"), "exiter.BigBinInit()\n") {
+			t.Fatalf("Standalone main does not init the app:\n%s", sources.Source(ExiterDir+"exiter/main.go"))
+		}
+		notes := []string{ExiterFilename + ":9:2: package level var home initialization can't be deferred"}
+		if !reflect.DeepEqual(sources.Notes(), notes) {
+			t.Fatalf("Expected notes %v but got %v", notes, sources.Notes())
+		}
+		sources = Restore("", ExiterDir)
+		if sources.Errors() != nil {
+			t.Fatalf("Restore failed:\n%v", sources.SingleError())
+		}
+		assertSource(t, sources, ExiterFilename, original)
+	}
+}
+
+// TestCallsFuncs validates that type conversions are not taken as calls, unlike calls within their arguments
+func TestCallsFuncs(t *testing.T) {
+	code := `package main
+
+import "os"
+
+type celsius float64
+
+var (
+	limit   = int64(5)
+	raw     = []byte("x")
+	temp    = celsius(20)
+	ptr     = (*int)(nil)
+	set     = map[string]bool(nil)
+	handler = (func())(nil)
+	home    = string(os.Getenv("HOME"))
+	count   = len(os.Args)
+)
+`
+	fileset := token.NewFileSet()
+	astfile, err := parser.ParseFile(fileset, "main.go", code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []string{
+		"main.go:14:2: package level var home initialization can't be deferred",
+		"main.go:15:2: package level var count initialization can't be deferred",
+	}
+	if reported := eagerInits(fileset, astfile); !reflect.DeepEqual(reported, notes) {
+		t.Fatalf("Expected notes %v but got %v", notes, reported)
+	}
+}
+
+// TestLazyInitFiles validates that the init funcs and flags of test files are left alone, external tests
+// just renamed, and a test hook runs the deferred ones, while inits of files that may be left out of the build
+// are left alone
+func TestLazyInitFiles(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	exiter = OriginalExiter
+	files := map[string]string{
+		ExiterDir + "exiter_test.go": "package main\n\nimport \"flag\"\n\nvar short = flag.Bool(\"quick\", false, \"\")\n\n" +
+			"func init() {\n\tflag.Parse()\n}\n",
+		ExiterDir + "exiter_linux.go":  "package main\n\nfunc init() {}\n",
+		ExiterDir + "exiter_cgo.go":    "//go:build cgo\n\npackage main\n\nfunc init() {}\n",
+		ExiterDir + "exiter_later.go":  "package main\n\nfunc init() {}\n",
+		ExiterDir + "external_test.go": "package main_test\n\nimport \"testing\"\n\nfunc TestExiter(t *testing.T) {}\n",
+	}
+	for filename, code := range files {
+		fakeExtraFiles[filename] = code
+		defer delete(fakeExtraFiles, filename)
+	}
+	sources := Generate("", ExiterDir)
+	if sources.Errors() != nil {
+		t.Fatalf("Generate failed:\n%v", sources.SingleError())
+	}
+	for filename, code := range files {
+		expected := strings.Replace(code, "package main", "package exiter", 1)
+		if filename == ExiterDir+"exiter_later.go" {
+			expected = strings.Replace(expected, "func init()", "func bigbinInit0()", 1)
+		}
+		assertSource(t, sources, filename, expected)
+	}
+	autoregister := sources.Source(ExiterDir + "exiter_autoregister.go")
+	if !strings.Contains(autoregister, "func BigBinInit() {\n\tbigbinInit0()\n}") || strings.Contains(autoregister, "Flags:") {
+		t.Fatalf("Unexpected autoregistration:\n%s", autoregister)
+	}
+	assertSource(t, sources, ExiterDir+"exiter_bigbin_test.go", Header+`Test hook
+package exiter
+
+// init runs the app init funcs for its tests, as no big binary runs them then
+func init() {
+	BigBinInit()
+}`)
+	if !strings.Contains(sources.Source(ExiterDir+ManifestFilename), `"exiter_bigbin_test.go": {`) {
+		t.Fatalf("Expected the manifest to record the test hook, but got:\n%s", sources.Source(ExiterDir+ManifestFilename))
+	}
+	notes := []string{
+		ExiterDir + "exiter_cgo.go:5:1: init func in a file with build constraints can't be deferred",
+		ExiterDir + "exiter_linux.go:3:1: init func in a file with build constraints can't be deferred",
+	}
+	if !reflect.DeepEqual(sources.Notes(), notes) {
+		t.Fatalf("Expected notes %v but got %v", notes, sources.Notes())
+	}
+}
+
+// TestLint validates conflicts registered at package level, or by init funcs that can't be deferred,
+// by several apps are reported, while flags and test files are left out
+func TestLint(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	files := map[string]string{
+		FlaggerDir + "flagger_linux.go": "package main\n\nimport \"net/http\"\n\nfunc init() {\n\thttp.Handle(\"/\", nil)\n}\n",
+		ExiterDir + "exiter_unix.go": "//go:build unix\n\npackage main\n\nimport (\n\t\"log\"\n\t\"net/http\"\n)\n\n" +
+			"func init() {\n\thttp.Handle(\"/\", nil)\n\tlog.SetPrefix(\"x\")\n}\n",
+		FlaggerDir + "flagger_test.go": "package main\n\nimport \"expvar\"\n\nvar requests = expvar.NewInt(\"requests\")\n",
+		ExiterDir + "external_test.go": "package main_test\n\nimport \"expvar\"\n\nvar requests = expvar.NewInt(\"requests\")\n",
+		ExiterDir + "exiter_test.go":   "package main\n\nimport \"expvar\"\n\nvar requests = expvar.NewInt(\"requests\")\n",
+	}
+	for filename, code := range files {
+		fakeExtraFiles[filename] = code
+		defer delete(fakeExtraFiles, filename)
+	}
+	flagger = OriginalFlagger
+	exiter = `package main
+
+import (
+	"expvar"
+	"flag"
+	"log"
+	web "net/http"
+)
+
+var v = flag.Int("v", 0, "verbosity")
+
+func init() {
+	expvar.NewInt("requests")
+	web.Handle("/", nil)
+	log.SetFlags(0)
+}
+
+func main() {
+	flag.Bool("other", false, "not at package level")
+}
+`
+	defer func() { exiter, flagger = OriginalExiter, OriginalFlagger }()
+	conflicts, err := Lint(App{Dir: FlaggerDir}, App{Dir: ExiterDir, Name: "quitter"}, App{Dir: SampleDir})
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	expected := []Conflict{
+		{Kind: "http handler", Name: "/", Apps: []string{"flagger", "quitter"},
+			Positions: []string{ExiterDir + "exiter_unix.go:11:2", FlaggerDir + "flagger_linux.go:6:2"}},
+		{Kind: "log settings", Name: "log.SetPrefix", Apps: []string{"quitter"},
+			Positions: []string{ExiterDir + "exiter_unix.go:12:2"}},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("Expected conflicts %v but got %v", expected, conflicts)
+	}
+}
+
+// TestOverlay validates that Overlay generates transformed copies, and the big binary main, within the shadow tree
+// without touching the original sources
+func TestOverlay(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	sample = OriginalSample
+	sources := Overlay(BigBinDir, SampleDir)
+	if sources.Errors() != nil {
+		t.Fatalf("Overlay failed:\n%v", sources.SingleError())
+	}
+	shadowDir := BigBinDir + OverlayDir + "/somewhere.com/someones/sample/"
+	shadowSample, shadowAutoRegister := shadowDir+"sample.go", shadowDir+"sample_autoregister.go"
+	shadowBigBin := BigBinDir + OverlayDir + "/main.go"
+	filenames := sources.Filenames()
+	if len(filenames) != 5 {
+		t.Fatalf("Expected 5 generated sources but got %d: %v", len(filenames), filenames)
+	}
+	assertSource(t, sources, shadowSample, ExpectedGeneratedSample)
+	assertSource(t, sources, shadowAutoRegister, ExpectedAutoRegister)
+	assertSource(t, sources, shadowBigBin, ExpectedBigBin)
+	if _, ok := sources.srcs[ExpectedBigBinFilename]; ok {
+		t.Fatalf("Overlay should not generate the big binary main outside the shadow tree")
+	}
+	var overlay overlayJSON
+	if err := json.Unmarshal([]byte(sources.Source(BigBinDir+OverlayFilename)), &overlay); err != nil {
+		t.Fatalf("Invalid overlay JSON: %v", err)
+	}
+	expected := map[string]string{
+		filepath.Clean(SampleFilename):               filepath.Clean(shadowSample),
+		filepath.Clean(ExpectedAutoRegisterFilename): filepath.Clean(shadowAutoRegister),
+		filepath.Clean(ExpectedBigBinFilename):       filepath.Clean(shadowBigBin),
+	}
+	if !reflect.DeepEqual(overlay.Replace, expected) {
+		t.Fatalf("Expected overlay replacements %v but got %v", expected, overlay.Replace)
+	}
+	for filename, _ := range RestoreOverlay(BigBinDir, SampleDir).srcs {
+		if _, ok := sources.srcs[filename]; !ok {
+			t.Fatalf("RestoreOverlay removes unexpected file %s", filename)
+		}
+	}
+	// within a module, filenames are relative to its root, so that the overlay works on any checkout
+	t.Setenv("GO111MODULE", "on")
+	appDir := "/work/tools/cmd/appa/"
+	fake := fakeDirs[SampleDir]
+	fake.filename = appDir + "appa.go"
+	fakeDirs[appDir] = fake
+	defer delete(fakeDirs, appDir)
+	sources = Overlay("/work/tools/cmd/all/", appDir)
+	if sources.Errors() != nil {
+		t.Fatalf("Overlay failed:\n%v", sources.SingleError())
+	}
+	overlay = overlayJSON{}
+	if err := json.Unmarshal([]byte(sources.Source("/work/tools/cmd/all/"+OverlayFilename)), &overlay); err != nil {
+		t.Fatalf("Invalid overlay JSON: %v", err)
+	}
+	shadowDir = "cmd/all/" + OverlayDir + "/example.com/tools/cmd/appa/"
+	expected = map[string]string{
+		"cmd/appa/appa.go":              shadowDir + "appa.go",
+		"cmd/appa/appa_autoregister.go": shadowDir + "appa_autoregister.go",
+		"cmd/all/main.go":               "cmd/all/" + OverlayDir + "/main.go",
+	}
+	if !reflect.DeepEqual(overlay.Replace, expected) {
+		t.Fatalf("Expected overlay replacements %v but got %v", expected, overlay.Replace)
+	}
+}
+
+// TestCheck validates that Check reports missing, changed and leftover files
+func TestCheck(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	sample = OriginalSample
+	sources := Generate(BigBinDir, SampleDir)
+	onDisk := map[string]string{
+		SampleFilename:               sources.Source(SampleFilename),
+		ExpectedStandAloneFilename:   strings.Replace(sources.Source(ExpectedStandAloneFilename), "Main()", "Main(1)", 1),
+		ExpectedBigBinFilename:       sources.Source(ExpectedBigBinFilename),
+		SampleDir + ManifestFilename: sources.Source(SampleDir + ManifestFilename),
+		BigBinDir + ManifestFilename: sources.Source(BigBinDir + ManifestFilename),
+	}
+	readFile = func(filename string) ([]byte, error) {
+		if src, ok := onDisk[filename]; ok {
+			return []byte(src), nil
+		}
+		return fakeReadFile(filename)
+	}
+	drifts, err := sources.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	expected := []Drift{
+		{Filename: ExpectedStandAloneFilename, Kind: Changed, Added: 1, Removed: 1},
+		{Filename: ExpectedAutoRegisterFilename, Kind: Missing, Added: 10},
+	}
+	if !reflect.DeepEqual(drifts, expected) {
+		t.Fatalf("Expected drifts %v but got %v", expected, drifts)
+	}
+	restored := Restore(BigBinDir, SampleDir)
+	if restored.Errors() == nil {
+		t.Fatalf("Restore should have refused to delete the modified %s", ExpectedStandAloneFilename)
+	}
+	drifts, err = restored.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	kinds := []DriftKind{}
+	for _, drift := range drifts {
+		kinds = append(kinds, drift.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []DriftKind{Leftover, Changed, Leftover, Leftover}) || drifts[1].Filename != SampleFilename {
+		t.Fatalf("Expected the changed sample and 3 leftovers but got %v", drifts)
+	}
+}
+
+// TestDiff validates that Diff outputs sorted unified diffs with new and deleted file headers,
+// marking last lines without newline, and fails for files outside the current directory
+func TestDiff(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	sample = OriginalSample
+	onDisk := map[string]string{SampleFilename: OriginalSample, ExpectedBigBinFilename: Header + "bigbin main\npackage main\n"}
+	readFile = func(filename string) ([]byte, error) {
+		if src, ok := onDisk[filename]; ok {
+			return []byte(src), nil
+		}
+		return fakeReadFile(filename)
+	}
+	sources := Restore(BigBinDir, SampleDir)
+	changed := strings.Replace(OriginalSample, "does some stuff", "does stuff", 1)
+	sources.srcs[SampleFilename] = []byte(strings.TrimSuffix(changed, "\n"))
+	patch, err := sources.Diff()
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	expected := `diff --git a/src/somewhere.com/someones/sample/sample.go b/src/somewhere.com/someones/sample/sample.go
+--- a/src/somewhere.com/someones/sample/sample.go
++++ b/src/somewhere.com/someones/sample/sample.go
+@@ -8,5 +8,5 @@
+ 	
+ // sample shows the calling args
+ func main() {
+-	fmt.Println("Sample does some stuff, here args are", os.Args)
+-}
++	fmt.Println("Sample does stuff, here args are", os.Args)
++}
+\ No newline at end of file
+diff --git a/src/somewhere.com/someones/sampleBigBin/main.go b/src/somewhere.com/someones/sampleBigBin/main.go
+deleted file mode 100644
+--- a/src/somewhere.com/someones/sampleBigBin/main.go
++++ /dev/null
+@@ -1,4 +0,0 @@
+-// Do NOT edit manually!
+-// Autogenerated by github.com/josvazg/bigbin/generator:
+-// bigbin main
+-package main
+`
+	if patch != expected {
+		t.Fatalf("Expected diff:\n%s\nBut got:\n%s", expected, patch)
+	}
+	sources = Generate(BigBinDir, SampleDir)
+	if patch, err = sources.Diff(); err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	newFile := `diff --git a/src/somewhere.com/someones/sample/sample/main.go b/src/somewhere.com/someones/sample/sample/main.go
+new file mode 100644
+--- /dev/null
++++ b/src/somewhere.com/someones/sample/sample/main.go
+@@ -0,0 +1,`
+	if !strings.Contains(patch, newFile) {
+		t.Fatalf("Expected diff to contain:\n%s\nBut got:\n%s", newFile, patch)
+	}
+	sources.srcs["../elsewhere.go"] = []byte("package elsewhere\n")
+	if _, err = sources.Diff(); err == nil {
+		t.Fatalf("Diff should fail for files outside the current directory")
+	}
+}
+
+// TestDiscover validates "dir/..." patterns find main packages, generated or not, skipping what they should
+func TestDiscover(t *testing.T) {
+	defer func(parse parseDirFunc, abs absPathFunc) { parseDir, absPath = parse, abs }(parseDir, absPath)
+	parseDir, absPath = defaultParseDir, defaultAbsPath
+	root := t.TempDir()
+	for filename, code := range map[string]string{
+		"cmd/appa/main.go":               "package main\n\nfunc main() {}\n",
+		"cmd/appa/main_test.go":          "package main\n",
+		"cmd/appb/b.go":                  "package appb\n\nfunc Main() {}\n",
+		"cmd/appb/appb_autoregister.go":  Header + "Autoregister code\npackage appb\n",
+		"cmd/appb/appb/main.go":          Header + "Standalone main for appb\npackage main\n\nfunc main() {}\n",
+		"cmd/appc/c.go":                  "package main\n\nfunc main() {}\n",
+		"cmd/lib/lib.go":                 "package lib\n\nfunc main() {}\n",
+		"cmd/testdata/appd/main.go":      "package main\n\nfunc main() {}\n",
+		"cmd/vendor/appe/main.go":        "package main\n\nfunc main() {}\n",
+		"cmd/_appf/main.go":              "package main\n\nfunc main() {}\n",
+		"cmd/all/main.go":                "package main\n\nfunc main() {}\n",
+		"cmd/nested/deep/appg/main.go":   "package main\n\nfunc main() {}\n",
+		"cmd/nested/deep/appg/README.md": "not go",
+	} {
+		filename = filepath.Join(root, filename)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := filepath.Join(root, "cmd")
+	mainDirs, err := Discover(filepath.Join(cmd, "all"), []string{"appc"}, cmd+RecursiveSuffix, cmd+"/appa")
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	expected := []string{filepath.Join(cmd, "appa"), filepath.Join(cmd, "appb"), filepath.Join(cmd, "nested/deep/appg")}
+	if !reflect.DeepEqual(mainDirs, expected) {
+		t.Fatalf("Expected main dirs %v but got %v", expected, mainDirs)
+	}
+	if _, err := Discover("", []string{filepath.Join(cmd, "nested", "*", "*")}, cmd+"/nested/..."); err == nil {
+		t.Fatalf("Discover should fail when a pattern matches no main packages")
+	}
+}
+
+// TestNames validates package names are valid identifiers, while app names keep their dashes or are overridden
+func TestNames(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	for dir, expected := range map[string]string{
+		SampleDir: "sample", "cmd/foo-bar": "foo_bar", "cmd/2fa/": "app2fa", "main": "mainapp", "cmd/gopkg.v2": "gopkg_v2",
+		"cmd/type": "typeapp", "cmd/go": "goapp", "cmd/1tool": "app1tool", "cmd/my.app": "my_app", "cmd/os": "osapp",
+		"cmd/bigbin": "bigbinapp",
+	} {
+		if name := packageName(dir); name != expected {
+			t.Fatalf("Expected package name %s for %s but got %s", expected, dir, name)
+		}
+	}
+	fmtDir := "src/somewhere.com/someones/fmt/"
+	fake := fakeDirs[SampleDir]
+	fake.filename = fmtDir + "fmt.go"
+	fakeDirs[fmtDir] = fake
+	defer delete(fakeDirs, fmtDir)
+	sources := Generate("", fmtDir)
+	if sources.Errors() != nil {
+		t.Fatalf("Generate failed:\n%v", sources.SingleError())
+	}
+	if !strings.Contains(sources.Source(fmtDir+"fmt.go"), "\npackage fmtapp\n") {
+		t.Fatalf("Expected package fmtapp, as fmt is imported, but got:\n%s", sources.Source(fmtDir+"fmt.go"))
+	}
+	notes := []string{fmtDir + `: "fmt" collides with an imported package name, package fmtapp is used instead`}
+	if !reflect.DeepEqual(sources.Notes(), notes) {
+		t.Fatalf("Expected notes %v but got %v", notes, sources.Notes())
+	}
+	srcs := newSources()
+	srcs.addStandAlone(App{Dir: "src/somewhere.com/someones/foo-bar/"}, mainInfo{packageName: "foo_bar"})
+	standAlone := srcs.Source("src/somewhere.com/someones/foo-bar/foo-bar/main.go")
+	if !strings.Contains(standAlone, `import foo_bar "somewhere.com/someones/foo-bar"`) {
+		t.Fatalf("Unexpected standalone main:\n%s", standAlone)
+	}
+	sources = GenerateApps("", App{Dir: SampleDir, Name: "sample-tool"})
+	if sources.Errors() != nil {
+		t.Fatalf("GenerateApps failed:\n%v", sources.SingleError())
+	}
+	assertSource(t, sources, ExpectedAutoRegisterFilename, strings.Replace(ExpectedAutoRegister,
+		`Name: "sample"`, `Name: "sample-tool"`, 1))
+	assertSource(t, sources, SampleDir+"sample-tool/main.go", ExpectedStandAlone)
+}
+
+// TestPackageDoc validates the package doc comment becomes the app long description when it says more than its synopsis
+func TestPackageDoc(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	docDir := "src/somewhere.com/someones/documented/"
+	code := strings.Replace(sample, "// Sample code\n", "// Sample code samples.\n//\n// It prints its args.\n", 1)
+	fakeDirs[docDir] = struct {
+		filename string
+		code     *string
+	}{docDir + "documented.go", &code}
+	defer delete(fakeDirs, docDir)
+	if synopsis, text := packageDoc(docDir); synopsis != "Sample code samples." ||
+		text != "Sample code samples.\n\nIt prints its args." {
+		t.Fatalf("Unexpected package doc %q %q", synopsis, text)
+	}
+	sources := Generate("", docDir, SampleDir)
+	if sources.Errors() != nil {
+		t.Fatalf("Generate failed:\n%v", sources.SingleError())
+	}
+	registration := `Short: "Sample code samples.", Long: "Sample code samples.\n\nIt prints its args."`
+	if !strings.Contains(sources.Source(docDir+"documented_autoregister.go"), registration) {
+		t.Fatalf("Expected %s but got:\n%s", registration, sources.Source(docDir+"documented_autoregister.go"))
+	}
+	assertSource(t, sources, ExpectedAutoRegisterFilename, ExpectedAutoRegister)
+}
+
+// TestGenerateBigBins validates several big binaries sharing apps generate each app just once
+func TestGenerateBigBins(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	clientDir := "src/somewhere.com/someones/clientBigBin/"
+	bigBins := []BigBinary{
+		{Dir: BigBinDir, Apps: Apps(SampleDir)},
+		{Dir: clientDir, Apps: Apps(SampleDir, ExiterDir)},
+	}
+	sources := GenerateBigBins(bigBins...)
+	if sources.Errors() != nil {
+		t.Fatalf("GenerateBigBins failed:\n%v", sources.SingleError())
+	}
+	assertSource(t, sources, ExpectedBigBinFilename, ExpectedBigBin)
+	assertSource(t, sources, clientDir+"main.go", strings.Replace(ExpectedBigBin, `_ "somewhere.com/someones/sample"`,
+		`_ "somewhere.com/someones/exiter"
+	_ "somewhere.com/someones/sample"`, 1))
+	assertSource(t, sources, ExpectedAutoRegisterFilename, ExpectedAutoRegister)
+	assertSource(t, sources, ExiterDir+"exiter_autoregister.go", ExpectedExiterAutoRegister)
+	sources = RestoreBigBins(bigBins...)
+	if sources.Errors() != nil {
+		t.Fatalf("RestoreBigBins failed:\n%v", sources.SingleError())
+	}
+	for _, filename := range []string{ExpectedBigBinFilename, clientDir + "main.go", ExpectedAutoRegisterFilename} {
+		if src, ok := sources.srcs[filename]; !ok || src != nil {
+			t.Fatalf("Expected %s to be removed", filename)
+		}
+	}
+	bigBins[1].Apps[0].Name = "other"
+	if sources := GenerateBigBins(bigBins...); sources.Errors() == nil {
+		t.Fatalf("GenerateBigBins should fail when a shared app is registered differently")
+	}
+	if sources := GenerateBigBins(bigBins[0], bigBins[0]); sources.Errors() == nil {
+		t.Fatalf("GenerateBigBins should fail when a big binary is generated twice")
+	}
+}
+
+// TestBuild validates Build lays the generated sources over the filesystem to build every binary
+func TestBuild(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	defer func() { goBuild = defaultGoBuild }()
+	builds := [][]string{}
+	goBuild = func(env []string, args ...string) error {
+		if env != nil {
+			return fmt.Errorf("Unexpected environment %v", env)
+		}
+		builds = append(builds, args)
+		overlay := overlayJSON{}
+		if src, err := os.ReadFile(args[2]); err != nil {
+			return err
+		} else if err := json.Unmarshal(src, &overlay); err != nil {
+			return err
+		}
+		if _, ok := overlay.Replace[ExpectedAutoRegisterFilename]; !ok || len(overlay.Replace) != 4 {
+			return fmt.Errorf("Unexpected overlay %v", overlay.Replace)
+		}
+		return os.WriteFile(args[len(args)-2], []byte("binary"), 0755)
+	}
+	bigBins := []BigBinary{{Dir: BigBinDir, Apps: []App{{Dir: SampleDir, Name: "sampler"}}}}
+	outDir := t.TempDir()
+	artifacts, err := GenerateBigBins(bigBins...).Build(bigBins,
+		BuildOptions{OutDir: outDir, StandAlones: true, LDFlags: "-s -w", TrimPath: true})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	checksum := "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd"
+	expected := []Artifact{{Path: filepath.Join(outDir, "sampleBigBin"), Size: 6, SHA256: checksum},
+		{Path: filepath.Join(outDir, "sampler"), Size: 6, SHA256: checksum}}
+	if !reflect.DeepEqual(artifacts, expected) {
+		t.Fatalf("Expected artifacts %v but got %v", expected, artifacts)
+	}
+	for i, target := range []string{"./src/somewhere.com/someones/sampleBigBin", "./src/somewhere.com/someones/sample/sampler"} {
+		args := append([]string{"build", "-overlay", builds[i][2], "-ldflags", "-s -w", "-trimpath"}, "-o", expected[i].Path, target)
+		if !reflect.DeepEqual(builds[i], args) {
+			t.Fatalf("Expected go %v but got %v", args, builds[i])
+		}
+	}
+}
+
+// TestBuildPlatforms validates Build cross compiles each platform into its own directory, with checksums
+func TestBuildPlatforms(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	defer func() { goBuild = defaultGoBuild }()
+	goBuild = func(env []string, args ...string) error {
+		return os.WriteFile(args[len(args)-2], []byte(strings.Join(env, " ")), 0755)
+	}
+	if _, err := ParsePlatforms("linux/amd64,linux"); err == nil {
+		t.Fatalf("ParsePlatforms should fail without arch")
+	}
+	platforms, err := ParsePlatforms("linux/arm64, windows/amd64")
+	if err != nil {
+		t.Fatalf("ParsePlatforms failed: %v", err)
+	}
+	bigBins := []BigBinary{{Dir: BigBinDir, Apps: Apps(SampleDir)}}
+	outDir := t.TempDir()
+	artifacts, err := GenerateBigBins(bigBins...).Build(bigBins, BuildOptions{OutDir: outDir, Platforms: platforms})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	sums := ""
+	for i, binary := range []string{"linux_arm64/sampleBigBin", "windows_amd64/sampleBigBin.exe"} {
+		env := "GOOS=" + platforms[i].OS + " GOARCH=" + platforms[i].Arch
+		sum := sha256.Sum256([]byte(env))
+		expected := Artifact{Platform: platforms[i], Path: filepath.Join(outDir, binary), Size: int64(len(env)),
+			SHA256: hex.EncodeToString(sum[:])}
+		if !reflect.DeepEqual(artifacts[i], expected) {
+			t.Fatalf("Expected artifact %v but got %v", expected, artifacts[i])
+		}
+		sums += expected.SHA256 + "  " + binary + "\n"
+	}
+	assertFiles(t, outDir, map[string]string{
+		"linux_arm64/sampleBigBin":       "GOOS=linux GOARCH=arm64",
+		"windows_amd64/sampleBigBin.exe": "GOOS=windows GOARCH=amd64",
+		ChecksumsFilename:                sums,
+	})
+}
+
+// TestConfig validates big binaries configurations are read and generate apps with their overrides
+func TestConfig(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	config, err := parseConfig(`# all our tools
+bigbins:
+  - to: ` + BigBinDir + `
+    apps:
+      - ` + SampleDir + `
+      - ` + ExiterDir + ` # exits
+    exclude: []
+    overrides:
+      ` + SampleDir + `:
+        name: "sampler"
+        aliases: [smp, 'sam #1']
+        short: Samples things: all of them
+        long: |
+          Samples things, one by one.
+
+          # Carefully
+  -
+    to: other
+    apps: [` + FlaggerDir + `]
+    overrides: {` + FlaggerDir + `: {aliases: [flg, "f, g"]}}
+`)
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+	sampler := App{Dir: SampleDir, Name: "sampler", Aliases: []string{"smp", "sam #1"}, Short: "Samples things: all of them",
+		Long: "Samples things, one by one.\n\n# Carefully\n"}
+	expected := &Config{BigBins: []BigBinConfig{
+		{To: BigBinDir, Apps: []string{SampleDir, ExiterDir}, Exclude: []string{},
+			Overrides: map[string]App{SampleDir: sampler}},
+		{To: "other", Apps: []string{FlaggerDir},
+			Overrides: map[string]App{FlaggerDir: {Dir: FlaggerDir, Aliases: []string{"flg", "f, g"}}}},
+	}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("Expected config %#v but got %#v", expected, config)
+	}
+	bigBin, err := config.BigBins[0].Resolve()
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if !reflect.DeepEqual(bigBin, BigBinary{Dir: BigBinDir, Apps: []App{sampler, {Dir: ExiterDir}}}) {
+		t.Fatalf("Unexpected big binary %#v", bigBin)
+	}
+	sources := GenerateApps(BigBinDir, bigBin.Apps...)
+	if sources.Errors() != nil {
+		t.Fatalf("GenerateApps failed:\n%v", sources.SingleError())
+	}
+	assertSource(t, sources, ExpectedAutoRegisterFilename, strings.Replace(ExpectedAutoRegister,
+		`Name: "sample", Short: "Sample code"`,
+		`Name: "sampler", Short: "Samples things: all of them", Long: "Samples things, one by one.\n\n# Carefully\n", `+
+			`Aliases: []string{"smp", "sam #1"}`, 1))
+	config.BigBins[1].Overrides["missing"] = App{Name: "x"}
+	if _, err := config.BigBins[1].Resolve(); err == nil {
+		t.Fatalf("Resolve should fail on overrides matching no app")
+	}
+	for src, expected := range map[string]interface{}{
+		"a: >\n  one\n  two\n\n  three\n    indented\nb: x\n": map[string]interface{}{
+			"a": "one two\nthree\n  indented\n", "b": "x"},
+		"- |-\n  kept\n   as is\n\n- >+\n  kept\n\n": []interface{}{"kept\n as is", "kept\n\n"},
+		"- {a: [b, {c: d}], e: }\n": []interface{}{map[string]interface{}{
+			"a": []interface{}{"b", map[string]interface{}{"c": "d"}}, "e": nil}},
+	} {
+		if value, err := parseYAML(src); err != nil || !reflect.DeepEqual(value, expected) {
+			t.Fatalf("Expected %#v parsing:\n%s\nbut got %#v, %v", expected, src, value, err)
+		}
+	}
+	for _, bad := range []string{
+		"bigbins:\n  - to: x\n",
+		"bigbins:\n  - to: x\n    apps: [a]\n    typo: y\n",
+		"bigbins:\n  - to: x\n     apps: [a]\n",
+		"bigbins:\n  - to: [x]\n    apps: [a]\n",
+		"bigbins:\n  - to: x\n    apps: [a\n",
+		"bigbins: &anchor\n",
+		"bigbins: {to: x\n",
+		"bigbins:\n  - to: x\n    apps: [a]\n    overrides: {x: |}\n",
+		"bigbins:\n  - long: |2\n      x\n",
+	} {
+		if _, err := parseConfig(bad); err == nil {
+			t.Fatalf("parseConfig should have failed for:\n%s", bad)
+		}
+	}
+}
+
+// TestApply validates Apply changes the filesystem all or nothing
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	existing, created, removed := filepath.Join(dir, "a.go"), filepath.Join(dir, "b", "c.go"), filepath.Join(dir, "d.go")
+	for filename, mode := range map[string]os.FileMode{existing: 0755, removed: 0644} {
+		if err := os.WriteFile(filename, []byte("old"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources := newSources()
+	sources.srcs[existing] = []byte("new a")
+	sources.srcs[created] = []byte("new c")
+	sources.srcs[removed] = nil
+	defer func() { renameFile = os.Rename }()
+	for failAt := 1; failAt <= 4; failAt++ {
+		renames := 0
+		renameFile = func(oldpath, newpath string) error {
+			if renames++; renames == failAt {
+				return fmt.Errorf("rename #%d failed", renames)
+			}
+			return os.Rename(oldpath, newpath)
+		}
+		err := sources.Apply()
+		applyErr, ok := err.(*ApplyError)
+		if !ok || applyErr.RollbackErrs != nil {
+			t.Fatalf("Expected Apply to fail and roll back at rename #%d, but got: %v", failAt, err)
+		}
+		assertFiles(t, dir, map[string]string{"a.go": "old", "d.go": "old"})
+		if _, err := os.Stat(filepath.Dir(created)); !os.IsNotExist(err) {
+			t.Fatalf("Directory %s should have been rolled back", filepath.Dir(created))
+		}
+	}
+	renameFile = os.Rename
+	if err := sources.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	assertFiles(t, dir, map[string]string{"a.go": "new a", "b/c.go": "new c"})
+	sources.srcs[created] = nil
+	if err := sources.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(created)); !os.IsNotExist(err) {
+		t.Fatalf("Directory %s should have been removed once empty", filepath.Dir(created))
+	}
+	if info, err := os.Stat(existing); err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("Apply should have kept %s permissions, but got: %v %v", existing, info.Mode(), err)
+	}
+}
+
+// assertFiles fails the test unless dir contains exactly the given files contents
+func assertFiles(t *testing.T, dir string, expected map[string]string) {
+	actual := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		actual[filepath.ToSlash(rel)] = string(contents)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected files %v but got %v", expected, actual)
+	}
+}
+
+// TestRestoreDirs validates Restore only removes the directories generation created, once left empty
+func TestRestoreDirs(t *testing.T) {
+	t.Setenv("GO111MODULE", "on")
+	defer func(parse parseDirFunc, abs absPathFunc, read readFileFunc, stat statFileFunc) {
+		parseDir, absPath, readFile, statFile = parse, abs, read, stat
+	}(parseDir, absPath, readFile, statFile)
+	parseDir, absPath, readFile, statFile = defaultParseDir, defaultAbsPath, os.ReadFile, os.Stat
+	root := t.TempDir()
+	for filename, code := range map[string]string{
+		"go.mod":           "module example.com/tools\n",
+		"cmd/appa/main.go": "package main\n\nfunc main() {}\n",
+	} {
+		filename = filepath.Join(root, filename)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	existing, created := filepath.Join(root, "cmd", "all"), filepath.Join(root, "bin", "all")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appDir := filepath.Join(root, "cmd", "appa")
+	for _, bigBinDir := range []string{existing, created} {
+		if err := Generate(bigBinDir, appDir).Apply(); err != nil {
+			t.Fatalf("Generate into %s failed: %v", bigBinDir, err)
+		}
+		if err := Restore(bigBinDir, appDir).Apply(); err != nil {
+			t.Fatalf("Restore from %s failed: %v", bigBinDir, err)
+		}
+	}
+	if _, err := os.Stat(existing); err != nil {
+		t.Fatalf("Restore should have kept the pre-existing %s: %v", existing, err)
+	}
+	for _, dir := range []string{created, filepath.Dir(created), filepath.Join(appDir, "appa")} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("Restore should have removed the generated %s", dir)
+		}
+	}
+	assertFiles(t, root, map[string]string{
+		"go.mod":           "module example.com/tools\n",
+		"cmd/appa/main.go": "package main\n\nfunc main() {}\n",
+	})
+}
+
+// TestManifest validates Restore undoes exactly what the manifest records, refusing to delete modified files
+func TestManifest(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	sample = OriginalSample
+	generated := Generate(BigBinDir, SampleDir)
+	if generated.Errors() != nil {
+		t.Fatalf("Generate failed:\n%v", generated.SingleError())
+	}
+	onDisk := make(map[string]string)
+	for _, filename := range generated.Filenames() {
+		onDisk[filename] = generated.Source(filename)
+	}
+	readFile = func(filename string) ([]byte, error) {
+		if src, ok := onDisk[filename]; ok {
+			return []byte(src), nil
+		}
+		return fakeReadFile(filename)
+	}
+	sample = ExpectedGeneratedSample
+	restored := Restore(BigBinDir, SampleDir)
+	if restored.Errors() != nil {
+		t.Fatalf("Restore failed:\n%v", restored.SingleError())
+	}
+	if len(restored.Filenames()) != len(generated.Filenames()) {
+		t.Fatalf("Expected Restore to undo %v but got %v", generated.Filenames(), restored.Filenames())
+	}
+	validate(t, restored, OriginalSample, RemovedFile, RemovedFile, RemovedFile)
+	if regenerated := Generate(BigBinDir, SampleDir); regenerated.String() != generated.String() {
+		t.Fatalf("Generate is not idempotent with a manifest, expected:\n%s\nBut got:\n%s", generated, regenerated)
+	}
+	if !strings.Contains(generated.Source(SampleDir+ManifestFilename), `"originalPackage": "main",
+			"originalFunc": "main"`) {
+		t.Fatalf("Expected the manifest to record the original names, but got:\n%s", generated.Source(SampleDir+ManifestFilename))
+	}
+	onDisk[ExpectedAutoRegisterFilename] += "// hand edited\n"
+	if Restore(BigBinDir, SampleDir).Errors() == nil {
+		t.Fatalf("Restore should have refused to delete the hand edited %s", ExpectedAutoRegisterFilename)
+	}
+	onDisk = map[string]string{ExpectedStandAloneFilename: "package main\n\n// hand written\nfunc main() {}\n"}
+	sample = OriginalSample
+	if Generate(BigBinDir, SampleDir).Errors() == nil {
+		t.Fatalf("Generate should have refused to overwrite the hand written %s", ExpectedStandAloneFilename)
+	}
+}
+
+// TestModulePkgpath validates import paths are resolved from go.mod files in module mode
+func TestModulePkgpath(t *testing.T) {
+	t.Setenv("GO111MODULE", "on")
+	gopath := setup()
+	defer shutdown(gopath)
+	for _, tc := range []struct{ importer, dir, expected string }{
+		{"/work/tools/cmd/all", "/work/tools/cmd/appa", "example.com/tools/cmd/appa"},
+		{"/work/tools/cmd/all", "/work/tools", "example.com/tools"},
+		{"/work/tools/cmd/all", "/work/tools/nested/appb", "example.com/nested/appb"},
+		{"/work/all", "/work/tools/cmd/appa", "example.com/tools/cmd/appa"},
+		{"/work/all/cmd", "/work/all/appc", "example.com/all/appc"},
+	} {
+		actual, err := importPath(tc.importer, tc.dir)
+		if err != nil {
+			t.Fatalf("importPath(%s, %s) failed: %v", tc.importer, tc.dir, err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("importPath(%s, %s) expected %s but got %s", tc.importer, tc.dir, tc.expected, actual)
+		}
+	}
+	if _, err := pkgpath("/elsewhere/app"); err == nil {
+		t.Fatalf("pkgpath outside any module or GOPATH should have failed")
+	}
+}
+
+// TestModuleReplace validates only local replace directives are taken into account
+func TestModuleReplace(t *testing.T) {
+	mod, err := parseModule("/work/all", []byte(goMods["/work/all/go.mod"]))
+	if err != nil {
+		t.Fatalf("parseModule failed: %v", err)
+	}
+	if len(mod.replaces) != 1 || mod.replaces["example.com/tools"] != "/work/tools" {
+		t.Fatalf("Expected a single local replace to /work/tools but got %v", mod.replaces)
+	}
+	if _, err := parseModule("/work/bad", []byte("go 1.21\n")); err == nil {
+		t.Fatalf("parseModule without module directive should have failed")
+	}
+}
+
+// TestSize validates the big binary savings and the size per package, counting only symbols taking file space
+func TestSize(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	defer func() { goBuild, goNm = defaultGoBuild, defaultGoNm }()
+	binaries := map[string]int{"sampleBigBin": 600, "sample": 500, "exiter": 300}
+	goBuild = func(env []string, args ...string) error {
+		output := args[len(args)-2]
+		return os.WriteFile(output, make([]byte, binaries[filepath.Base(output)]), 0755)
+	}
+	goNm = func(binary string) ([]byte, error) {
+		return []byte(`  4a5b20        300 T runtime.mallocgc
+  4a5c00        100 T runtime.(*mheap).alloc
+  5b0000         80 R type:*net/http.Client
+  5b0100         40 T net/http.(*Client).Do
+  5c0000         60 T somewhere.com/someones/sample.main
+  5c0100         20 T slices.SortFunc[go.shape.string,func(string, string) int]
+  600000         40 D go:itab.*os.File,io.Writer
+  700000   33554432 B crypto/internal/fips140/drbg.memory
+  700100         16 b runtime.bss
+                  0 U _cgo_init
+`), nil
+	}
+	bigBin := BigBinary{Dir: BigBinDir, Apps: Apps(SampleDir, ExiterDir)}
+	report, err := GenerateBigBins(bigBin).Size(bigBin, BuildOptions{})
+	if err != nil {
+		t.Fatalf("Size failed: %v", err)
+	}
+	if report.BigBinary.Size != 600 || len(report.StandAlones) != 2 || report.Sum() != 800 || report.Savings() != 25 {
+		t.Fatalf("Expected 600 bytes saving 25%% of 800 but got %d saving %.1f%% of %d",
+			report.BigBinary.Size, report.Savings(), report.Sum())
+	}
+	expected := []PackageSize{
+		{"runtime", 400}, {"net/http", 120}, {"somewhere.com/someones/sample", 60}, {"go:", 40}, {"slices", 20},
+	}
+	if !reflect.DeepEqual(report.Packages, expected) {
+		t.Fatalf("Expected packages %v but got %v", expected, report.Packages)
+	}
+	for _, bigBin := range []BigBinary{{Apps: Apps(SampleDir, ExiterDir)}, {Dir: BigBinDir}} {
+		if _, err := GenerateBigBins(bigBin).Size(bigBin, BuildOptions{}); err == nil {
+			t.Fatalf("Size should have failed for %v", bigBin)
+		}
+	}
+}
+
+// TestImage validates the OCI image layout holds the big binary with its app links, and the configured entrypoint
+func TestImage(t *testing.T) {
+	gopath := setup()
+	defer shutdown(gopath)
+	defer func() { goBuild = defaultGoBuild }()
+	goBuild = func(env []string, args ...string) error {
+		return os.WriteFile(args[len(args)-2], []byte("binary"), 0755)
+	}
+	apps := Apps(SampleDir, ExiterDir)
+	apps[1].Aliases = []string{"quit"}
+	bigBin := BigBinary{Dir: BigBinDir, Apps: apps}
+	image := ImageOptions{Path: "/bin/sampleBigBin", Entrypoint: "quit", Platform: Platform{"linux", "arm64"},
+		Tag: "sample:1.0"}
+	filename := filepath.Join(t.TempDir(), "image.tar")
+	if err := GenerateBigBins(bigBin).Image(bigBin, BuildOptions{}, ImageOptions{Path: "/bin/sampleBigBin",
+		Entrypoint: "missing"}, filename); err == nil {
+		t.Fatalf("Image should fail with an entrypoint that is not an app")
+	}
+	if err := GenerateBigBins(bigBin).Image(bigBin, BuildOptions{}, image, filename); err != nil {
+		t.Fatalf("Image failed: %v", err)
+	}
+	blobs := readTar(t, mustReadFile(t, filename))
+	var index ociIndex
+	var manifest ociManifest
+	var config ociImageConfig
+	mustUnmarshal(t, blobs["index.json"], &index)
+	if index.Manifests[0].Annotations[ociRefNameAnnotation] != "sample:1.0" {
+		t.Fatalf("Expected image tagged sample:1.0 but got %v", index.Manifests[0].Annotations)
+	}
+	mustUnmarshal(t, blobs[blobPath(index.Manifests[0])], &manifest)
+	mustUnmarshal(t, blobs[blobPath(manifest.Config)], &config)
+	if config.OS != "linux" || config.Architecture != "arm64" ||
+		!reflect.DeepEqual(config.Config.Entrypoint, []string{"/bin/quit"}) {
+		t.Fatalf("Unexpected image config %+v", config)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(blobs[blobPath(manifest.Layers[0])]))
+	if err != nil {
+		t.Fatalf("Layer is not gzipped: %v", err)
+	}
+	layer, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Couldn't read layer: %v", err)
+	}
+	if diffID := sha256.Sum256(layer); config.RootFS.DiffIDs[0] != "sha256:"+hex.EncodeToString(diffID[:]) {
+		t.Fatalf("Layer diff ID does not match %v", config.RootFS.DiffIDs)
+	}
+	expected := map[string]string{"bin/": "", "bin/sampleBigBin": "binary",
+		"bin/sample": "-> sampleBigBin", "bin/exiter": "-> sampleBigBin", "bin/quit": "-> sampleBigBin"}
+	if files := readTar(t, layer); !reflect.DeepEqual(files, toBytes(expected)) {
+		t.Fatalf("Expected layer %v but got %q", expected, files)
+	}
+}
+
 //
 // Helper functions and mocking infrastructure
 //
@@ -120,13 +1422,20 @@ func TestRestore(t *testing.T) {
 func setup() string {
 	parseDir = fakeParseDir
 	absPath = fakeAbsPath
+	readFile = fakeReadFile
+	statFile = fakeStatFile
 	gopath := os.Getenv("GOPATH")
 	os.Setenv("GOPATH", "")
 	return gopath
 }
 
 func validate(t *testing.T, sources *Sources, sample, autoRegister, standAlone, bigbin string) {
-	filenames := sources.Filenames()
+	filenames := []string{}
+	for _, filename := range sources.Filenames() {
+		if filepath.Base(filename) != ManifestFilename {
+			filenames = append(filenames, filename)
+		}
+	}
 	expectedSources := 4
 	if len(filenames) != expectedSources {
 		t.Fatalf("Expected %d generated sources but got %d: %v", expectedSources, len(filenames), filenames)
@@ -158,21 +1467,51 @@ func assertSource(t *testing.T, sources *Sources, filename, rawExpected string)
 	}
 }
 
+// fakeDirs maps the in memory test directories to their only file name & code
+var fakeDirs = map[string]struct {
+	filename string
+	code     *string
+}{
+	SampleDir:  {SampleFilename, &sample},
+	ExiterDir:  {ExiterFilename, &exiter},
+	FlaggerDir: {FlaggerFilename, &flagger},
+}
+
+// fakeExtraFiles maps more in memory test file names to their code, parsed along their directory only file
+var fakeExtraFiles = map[string]string{}
+
 // fakeParseDir parses this test code instead of filesystem directories
 func fakeParseDir(fileset *token.FileSet, dir string) (map[string]*ast.Package, error) {
-	if dir == SampleDir {
+	if fake, ok := fakeDirs[dir]; ok {
 		packages := make(map[string]*ast.Package)
-		src, err := parser.ParseFile(fileset, SampleFilename, sample, parser.ParseComments|parser.AllErrors)
+		src, err := parser.ParseFile(fileset, fake.filename, *fake.code, parser.ParseComments|parser.AllErrors)
 		if err != nil {
 			return nil, fmt.Errorf("Can't parse in memory test file: %v", err)
 		}
 		files := make(map[string]*ast.File)
-		files[SampleFilename] = src
-		pkg, err := ast.NewPackage(fileset, files, fakeImporter(), ast.NewScope(nil))
+		files[fake.filename] = src
+		for filename, code := range fakeExtraFiles {
+			if filepath.Dir(filename) != filepath.Dir(fake.filename) {
+				continue
+			}
+			extra, err := parser.ParseFile(fileset, filename, code, parser.ParseComments)
+			if err != nil {
+				return nil, fmt.Errorf("Can't parse in memory test file: %v", err)
+			}
+			if extra.Name.Name == src.Name.Name {
+				files[filename] = extra
+				continue
+			}
+			if packages[extra.Name.Name] == nil { // as an external test package
+				packages[extra.Name.Name] = &ast.Package{Name: extra.Name.Name, Files: make(map[string]*ast.File)}
+			}
+			packages[extra.Name.Name].Files[filename] = extra
+		}
+		pkg, err := ast.NewPackage(fileset, files, fakeImporter(), fakeUniverse())
 		if err != nil {
 			return nil, fmt.Errorf("Can't create in memory test package: %v", err)
 		}
-		packages["main"] = pkg
+		packages[src.Name.Name] = pkg
 		return packages, nil
 	}
 	return nil, fmt.Errorf("Can't generate test packages for unexpected directory %s", dir)
@@ -183,12 +1522,86 @@ func fakeAbsPath(dir string) (string, error) {
 	return dir, nil
 }
 
+// fakeStatFile pretends all files exist
+func fakeStatFile(filename string) (os.FileInfo, error) {
+	return nil, nil
+}
+
+// fakeReadFile serves the in memory goMods instead of filesystem files
+func fakeReadFile(filename string) ([]byte, error) {
+	if src, ok := goMods[filepath.ToSlash(filename)]; ok {
+		return []byte(src), nil
+	}
+	return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+}
+
+// fakeUniverse declares just the builtins used by the test code
+func fakeUniverse() *ast.Scope {
+	universe := ast.NewScope(nil)
+	for _, name := range []string{"bool", "int", "string"} {
+		universe.Insert(ast.NewObj(ast.Typ, name))
+	}
+	universe.Insert(ast.NewObj(ast.Con, "false"))
+	universe.Insert(ast.NewObj(ast.Con, "nil"))
+	universe.Insert(ast.NewObj(ast.Var, FlagSetVar)) // as declared by the autoregistration code
+	return universe
+}
+
 // fakeImporter just supports the imports for this tests
 func fakeImporter() ast.Importer {
 	return func(imports map[string]*ast.Object, path string) (pkg *ast.Object, err error) {
-		if path == "fmt" || path == "os" {
-			return ast.NewObj(ast.Pkg, path), nil
+		switch path {
+		case "expvar", "flag", "fmt", "log", "net/http", "os", "strings":
+			return ast.NewObj(ast.Pkg, filepath.Base(path)), nil
 		}
 		return nil, fmt.Errorf("Unsupported input imports=%v path=%s", imports, path)
 	}
 }
+
+// readTar returns the contents of the entries in tarball, as "-> target" for symlinks
+func readTar(t *testing.T, tarball []byte) map[string][]byte {
+	files := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(tarball))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("Couldn't read tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Couldn't read %s: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			content = []byte("-> " + header.Linkname)
+		}
+		files[header.Name] = content
+	}
+}
+
+// toBytes converts the string contents of files to bytes
+func toBytes(files map[string]string) map[string][]byte {
+	contents := make(map[string][]byte)
+	for name, content := range files {
+		contents[name] = []byte(content)
+	}
+	return contents
+}
+
+// mustReadFile returns the contents of filename, failing the test if it can't be read
+func mustReadFile(t *testing.T, filename string) []byte {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Couldn't read %s: %v", filename, err)
+	}
+	return content
+}
+
+// mustUnmarshal decodes the JSON blob into v, failing the test if it can't be decoded
+func mustUnmarshal(t *testing.T, blob []byte, v interface{}) {
+	if err := json.Unmarshal(blob, v); err != nil {
+		t.Fatalf("Couldn't unmarshal %s: %v", blob, err)
+	}
+}
@@ -0,0 +1,246 @@
+package generator
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OCI media types of the image parts written by Image
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// ImageOptions tell Image where to place the big binary within the image and what to run by default
+type ImageOptions struct {
+	Path       string   // Path of the big binary within the image, the app symlinks are placed next to it
+	Entrypoint string   // Entrypoint is the app name (or alias) to run by default, the big binary itself if empty
+	Platform   Platform // Platform to build the image for
+	Tag        string   // Tag is the image reference, such as "mybigbin:latest", if any
+}
+
+// ociDescriptor points to a blob within the image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociImageConfig is the subset of the OCI image configuration Image fills in
+type ociImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Entrypoint []string `json:"Entrypoint"`
+	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ociManifest is the OCI image manifest
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociIndex is the OCI image layout index.json
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// dockerManifest is a manifest.json entry, so that older docker load versions also take the image
+type dockerManifest struct {
+	Config   string
+	RepoTags []string `json:",omitempty"`
+	Layers   []string
+}
+
+// Image builds bigBin from srcs for image.Platform and writes an OCI image layout tarball to filename,
+// ready for "docker load" or any other OCI tool, with no daemon involved.
+//
+// The image has a single layer holding the big binary at image.Path along with a symlink per app name
+// and alias next to it, as "mybigbin --install" would do, so no install step is needed at runtime.
+// Build options are passed through, but for OutDir, StandAlones and Platforms. Note minimal images
+// lacking a libc need static binaries, as built with CGO_ENABLED=0 in the environment.
+func (srcs *Sources) Image(bigBin BigBinary, opts BuildOptions, image ImageOptions, filename string) error {
+	if !path.IsAbs(image.Path) {
+		return fmt.Errorf("Image path %q must be absolute", image.Path)
+	}
+	links, entrypoint := imageLinks(bigBin, image)
+	if entrypoint == "" {
+		return fmt.Errorf("Entrypoint %s is not an app of %s", image.Entrypoint, bigBin.Dir)
+	}
+	outDir, err := os.MkdirTemp("", "bigbin-image")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+	opts.OutDir, opts.StandAlones, opts.Platforms = outDir, false, []Platform{image.Platform}
+	artifacts, err := srcs.Build([]BigBinary{bigBin}, opts)
+	if err != nil {
+		return err
+	}
+	binary, err := os.ReadFile(artifacts[0].Path)
+	if err != nil {
+		return err
+	}
+	layer, diffID, err := imageLayer(image.Path, binary, links)
+	if err != nil {
+		return err
+	}
+	config := ociImageConfig{Architecture: image.Platform.Arch, OS: image.Platform.OS}
+	config.Config.Entrypoint = []string{entrypoint}
+	config.RootFS.Type, config.RootFS.DiffIDs = "layers", []string{diffID}
+	configBlob, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	manifest := ociManifest{SchemaVersion: 2, MediaType: ociManifestMediaType,
+		Config: descriptor(ociConfigMediaType, configBlob), Layers: []ociDescriptor{descriptor(ociLayerMediaType, layer)}}
+	manifestBlob, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	index := ociIndex{SchemaVersion: 2, MediaType: ociIndexMediaType,
+		Manifests: []ociDescriptor{descriptor(ociManifestMediaType, manifestBlob)}}
+	docker := dockerManifest{Config: blobPath(manifest.Config), Layers: []string{blobPath(manifest.Layers[0])}}
+	if image.Tag != "" {
+		index.Manifests[0].Annotations = map[string]string{ociRefNameAnnotation: image.Tag}
+		docker.RepoTags = []string{image.Tag}
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	dockerJSON, err := json.Marshal([]dockerManifest{docker})
+	if err != nil {
+		return err
+	}
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{"oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{"index.json", indexJSON},
+		{"manifest.json", dockerJSON},
+		{blobPath(manifest.Config), configBlob},
+		{blobPath(manifest.Layers[0]), layer},
+		{blobPath(index.Manifests[0]), manifestBlob},
+	} {
+		if err := writeTarFile(tw, file.name, 0644, file.content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, tarball.Bytes(), 0644)
+}
+
+// imageLinks returns the app names and aliases of bigBin to symlink next to the big binary, sorted,
+// and the entrypoint path for image, empty if image.Entrypoint is not one of them
+func imageLinks(bigBin BigBinary, image ImageOptions) (links []string, entrypoint string) {
+	binaryName := path.Base(image.Path)
+	if image.Entrypoint == "" || image.Entrypoint == binaryName {
+		entrypoint = image.Path
+	}
+	for _, app := range bigBin.Apps {
+		for _, name := range append([]string{appName(app)}, app.Aliases...) {
+			if name == binaryName {
+				continue
+			}
+			links = append(links, name)
+			if name == image.Entrypoint {
+				entrypoint = path.Join(path.Dir(image.Path), name)
+			}
+		}
+	}
+	sort.Strings(links)
+	return links, entrypoint
+}
+
+// imageLayer returns the gzipped tar layer holding binary at binaryPath, its parent directories and links
+// to it next to it, along with the layer diff ID, the digest of the uncompressed tar
+func imageLayer(binaryPath string, binary []byte, links []string) ([]byte, string, error) {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	dir := strings.TrimPrefix(path.Dir(binaryPath), "/")
+	parents := []string{}
+	for ; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+	for _, parent := range parents {
+		header := &tar.Header{Typeflag: tar.TypeDir, Name: parent + "/", Mode: 0755, ModTime: time.Unix(0, 0)}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, "", err
+		}
+	}
+	name := strings.TrimPrefix(binaryPath, "/")
+	if err := writeTarFile(tw, name, 0755, binary); err != nil {
+		return nil, "", err
+	}
+	for _, link := range links {
+		header := &tar.Header{Typeflag: tar.TypeSymlink, Name: path.Join(path.Dir(name), link),
+			Linkname: path.Base(name), Mode: 0777, ModTime: time.Unix(0, 0)}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	diffID := sha256.Sum256(layer.Bytes())
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write(layer.Bytes()); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return gzipped.Bytes(), "sha256:" + hex.EncodeToString(diffID[:]), nil
+}
+
+// writeTarFile writes a regular file entry into tw, with a fixed timestamp so that images are reproducible
+func writeTarFile(tw *tar.Writer, name string, mode int64, content []byte) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: int64(len(content)),
+		ModTime: time.Unix(0, 0)}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// descriptor describes blob as mediaType
+func descriptor(mediaType string, blob []byte) ociDescriptor {
+	sum := sha256.Sum256(blob)
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(blob))}
+}
+
+// blobPath returns where the blob described by desc lives within the image layout
+func blobPath(desc ociDescriptor) string {
+	return "blobs/sha256/" + strings.TrimPrefix(desc.Digest, "sha256:")
+}
@@ -0,0 +1,168 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/build/constraint"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// InitFunc is the per app initializer declared by the autoregistration code,
+// running the app init funcs only when the app is the one being run
+const InitFunc = "BigBinInit"
+
+// lazyInitPrefix prefixes the numbered names the app init funcs are renamed to
+const lazyInitPrefix = "bigbinInit"
+
+// toLazyInits modifies astfile so that its init funcs no longer run on every bigbin invocation,
+// but when InitFunc gets called:
+//
+// "func init()" -> "func bigbinInit{N}()"
+//
+// Funcs are numbered from next onwards, renumbering those renamed beforehand, so that the order
+// in which Go would have run them is kept. Returns the renamed funcs names, in order.
+func toLazyInits(astfile *ast.File, next int) []string {
+	names := []string{}
+	for _, decl := range astfile.Decls {
+		if fndecl, ok := decl.(*ast.FuncDecl); ok && isInitFunc(fndecl) {
+			fndecl.Name = ast.NewIdent(lazyInitPrefix + strconv.Itoa(next+len(names)))
+			names = append(names, fndecl.Name.Name)
+		}
+	}
+	return names
+}
+
+// constrained tells whether filename, parsed as astfile, is left out of some builds, either by its
+// GOOS or GOARCH suffix, its build constraints or because it uses cgo. The generated code is meant for
+// every platform, so the init funcs of such files can't be renamed, as they could be missing when called.
+func constrained(filename string, astfile *ast.File) bool {
+	for _, group := range astfile.Comments {
+		if group.Pos() > astfile.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if constraint.IsGoBuild(comment.Text) || constraint.IsPlusBuild(comment.Text) {
+				return true
+			}
+		}
+	}
+	if importName(astfile, "C") != "" {
+		return true
+	}
+	// no file name suffix matches this made up platform, so only those with a suffix get excluded
+	probe := build.Context{GOOS: "bigbin", GOARCH: "bigbin", OpenFile: func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("package main\n")), nil
+	}}
+	match, err := probe.MatchFile(filepath.Dir(filename), filepath.Base(filename))
+	return err != nil || !match
+}
+
+// constrainedInits reports the init funcs of astfile, a constrained file, as they run on every bigbin invocation
+func constrainedInits(fileset *token.FileSet, astfile *ast.File) []string {
+	report := []string{}
+	for _, decl := range astfile.Decls {
+		if fndecl, ok := decl.(*ast.FuncDecl); ok && isInitFunc(fndecl) {
+			report = append(report, fmt.Sprintf("%s: init func in a file with build constraints can't be deferred",
+				fileset.Position(fndecl.Pos())))
+		}
+	}
+	return report
+}
+
+// fromLazyInits undoes toLazyInits, renaming the funcs back to init
+func fromLazyInits(astfile *ast.File) {
+	for _, decl := range astfile.Decls {
+		if fndecl, ok := decl.(*ast.FuncDecl); ok && isInitFunc(fndecl) {
+			fndecl.Name = ast.NewIdent("init")
+		}
+	}
+}
+
+// isInitFunc tells whether fndecl is an init func, either original or already renamed by toLazyInits
+func isInitFunc(fndecl *ast.FuncDecl) bool {
+	if fndecl.Recv != nil || fndecl.Body == nil {
+		return false
+	}
+	name := fndecl.Name.Name
+	if name == "init" {
+		return true
+	}
+	n := strings.TrimPrefix(name, lazyInitPrefix)
+	_, err := strconv.Atoi(n)
+	return n != name && err == nil
+}
+
+// eagerInits reports the package level vars of astfile whose initialization calls functions,
+// and so can't be deferred into InitFunc, as they run on every bigbin invocation.
+// Flag registrations into the app flag set are not reported, as they are cheap and isolated already.
+func eagerInits(fileset *token.FileSet, astfile *ast.File) []string {
+	report := []string{}
+	for _, decl := range astfile.Decls {
+		gendecl, ok := decl.(*ast.GenDecl)
+		if !ok || gendecl.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gendecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for _, value := range valueSpec.Values {
+				if callsFuncs(value) {
+					report = append(report, fmt.Sprintf("%s: package level var %s initialization can't be deferred",
+						fileset.Position(valueSpec.Pos()), valueSpec.Names[0].Name))
+					break
+				}
+			}
+		}
+	}
+	return report
+}
+
+// callsFuncs tells whether evaluating expr calls any function, other than flag set registrations.
+// Function literals are not evaluated, so calls within their bodies do not count, nor do type conversions,
+// but for those to types from other files or packages, which can't be told apart from calls without type checking.
+func callsFuncs(expr ast.Expr) bool {
+	calls := false
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if isType(node.Fun) {
+				break
+			}
+			if selector, ok := node.Fun.(*ast.SelectorExpr); !ok ||
+				!isIdent(selector.X, FlagSetVar) || !flagRegistrations[selector.Sel.Name] {
+				calls = true
+			}
+		}
+		return !calls
+	})
+	return calls
+}
+
+// isType tells whether expr is known to be a type, so that calling it is a conversion
+func isType(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.ParenExpr:
+		return isType(expr.X)
+	case *ast.StarExpr:
+		return isType(expr.X)
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.StructType:
+		return true
+	case *ast.Ident:
+		if expr.Obj != nil {
+			return expr.Obj.Kind == ast.Typ
+		}
+		return builtinTypes[expr.Name]
+	}
+	return false
+}
+
+// builtinTypes are the predeclared type names
+var builtinTypes = names("any", "bool", "byte", "comparable", "complex64", "complex128", "error", "float32",
+	"float64", "int", "int8", "int16", "int32", "int64", "rune", "string", "uint", "uint8", "uint16", "uint32",
+	"uint64", "uintptr")
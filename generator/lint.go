@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Conflict is a problem, found by Lint, caused by combining several applets into a single binary
+type Conflict struct {
+	Kind      string   // Kind of conflict, such as "http handler", "expvar" or "log settings"
+	Name      string   // Name in conflict, such as the handler pattern, expvar key or log function
+	Apps      []string // Apps involved, sorted
+	Positions []string // Positions of every occurrence
+}
+
+// String returns a one line summary of the conflict
+func (conflict Conflict) String() string {
+	if conflict.Kind == logSettings {
+		return fmt.Sprintf("%s: %s called at init by %s changes it for all apps (%s)", conflict.Kind, conflict.Name,
+			strings.Join(conflict.Apps, ", "), strings.Join(conflict.Positions, ", "))
+	}
+	return fmt.Sprintf("%s: %q registered by %s (%s)", conflict.Kind, conflict.Name,
+		strings.Join(conflict.Apps, ", "), strings.Join(conflict.Positions, ", "))
+}
+
+const logSettings = "log settings"
+
+// lintedCall describes a global registration or setting to check for conflicts
+type lintedCall struct {
+	kind     string
+	nameArg  int  // index of the argument with the registered name, or -1 to use the function name
+	shared   bool // conflicts as soon as a single app calls it, as it alters all apps
+	funcName map[string]bool
+}
+
+// lintedCalls are the package level calls checked by Lint, by import path.
+// Flags are left out, as every app gets its own flag set.
+var lintedCalls = map[string][]lintedCall{
+	"net/http": {{kind: "http handler", nameArg: 0, funcName: names("Handle", "HandleFunc")}},
+	"expvar":   {{kind: "expvar", nameArg: 0, funcName: names("Publish", "NewFloat", "NewInt", "NewMap", "NewString")}},
+	"log":      {{kind: logSettings, nameArg: -1, shared: true, funcName: names("SetFlags", "SetOutput", "SetPrefix")}},
+}
+
+// Lint checks the main packages of the given apps for conflicts that would arise when combined into a big binary,
+// before generating any code.
+//
+// As every applet's package level vars run on every big binary invocation, as do the init funcs that can't be
+// deferred until the app runs, those in constrained files, it reports http.DefaultServeMux patterns and expvar keys
+// registered there by several apps, as well as any log settings changed there.
+// Deferred init funcs only run for their own app, so they can't conflict, and neither can flags, as every app
+// gets its own flag set. Test files are not checked. Names not given as literals are not checked.
+//
+// Apps are reported by name, as they would be invoked from the big binary.
+// Conflicts are returned sorted by kind and name, along with an error if any package failed to parse.
+func Lint(apps ...App) ([]Conflict, error) {
+	occurrences := make(map[[2]string]*Conflict)
+	for _, linted := range apps {
+		fileset := token.NewFileSet()
+		packages, err := parseDir(fileset, linted.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse directory %s:%v", linted.Dir, err)
+		}
+		app := appName(linted)
+		for _, astpkg := range packages {
+			if strings.HasSuffix(astpkg.Name, "_test") {
+				continue
+			}
+			for filename, astfile := range astpkg.Files {
+				if strings.HasSuffix(filename, "_test.go") {
+					continue
+				}
+				lintFile(filename, astfile, func(kind, name string, pos token.Pos) {
+					key := [2]string{kind, name}
+					if occurrences[key] == nil {
+						occurrences[key] = &Conflict{Kind: kind, Name: name}
+					}
+					conflict := occurrences[key]
+					conflict.Positions = append(conflict.Positions, fileset.Position(pos).String())
+					if !contains(conflict.Apps, app) {
+						conflict.Apps = append(conflict.Apps, app)
+					}
+				})
+			}
+		}
+	}
+	conflicts := []Conflict{}
+	for _, conflict := range occurrences {
+		if len(conflict.Apps) > 1 || conflict.Kind == logSettings {
+			sort.Strings(conflict.Apps)
+			sort.Strings(conflict.Positions)
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Kind != conflicts[j].Kind {
+			return conflicts[i].Kind < conflicts[j].Kind
+		}
+		return conflicts[i].Name < conflicts[j].Name
+	})
+	return conflicts, nil
+}
+
+// lintFile reports every linted call found in the var declarations of filename, parsed as astfile,
+// and in its init funcs if they can't be deferred, see constrained
+func lintFile(filename string, astfile *ast.File, report func(kind, name string, pos token.Pos)) {
+	imported := make(map[string]string) // import name -> import path
+	for path := range lintedCalls {
+		if name := importName(astfile, path); name != "" {
+			imported[name] = path
+		}
+	}
+	if len(imported) == 0 {
+		return
+	}
+	inspect := func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		pkg, ok := selector.X.(*ast.Ident)
+		if !ok || imported[pkg.Name] == "" {
+			return true
+		}
+		for _, linted := range lintedCalls[imported[pkg.Name]] {
+			if !linted.funcName[selector.Sel.Name] {
+				continue
+			}
+			if linted.nameArg < 0 {
+				report(linted.kind, pkg.Name+"."+selector.Sel.Name, call.Pos())
+			} else if linted.nameArg < len(call.Args) {
+				if name, ok := stringLiteral(call.Args[linted.nameArg]); ok {
+					report(linted.kind, name, call.Pos())
+				}
+			}
+		}
+		return true
+	}
+	for _, decl := range astfile.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Tok == token.VAR {
+				ast.Inspect(decl, inspect)
+			}
+		case *ast.FuncDecl:
+			if isInitFunc(decl) && constrained(filename, astfile) {
+				ast.Inspect(decl.Body, inspect)
+			}
+		}
+	}
+}
+
+// stringLiteral returns the value of expr if it is a string literal
+func stringLiteral(expr ast.Expr) (string, bool) {
+	literal, ok := expr.(*ast.BasicLit)
+	if !ok || literal.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(literal.Value)
+	return value, err == nil
+}
+
+// names builds a set of names
+func names(list ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range list {
+		set[name] = true
+	}
+	return set
+}
+
+// contains tells whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package generator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFilename is the file, within each applet and bigbin directory, recording what was generated there
+const ManifestFilename = ".bigbin.json"
+
+// manifest records the files generated or modified within a directory, relative to it
+type manifest struct {
+	Files map[string]*manifestEntry `json:"files"`
+	Dirs  []string                  `json:"dirs,omitempty"` // directories created for the generated files
+}
+
+// manifestEntry records a generated or modified file
+type manifestEntry struct {
+	SHA256          string `json:"sha256"`                    // hash of the generated contents
+	Created         bool   `json:"created,omitempty"`         // whether the file did not exist before
+	OriginalPackage string `json:"originalPackage,omitempty"` // package name of the modified file before
+	OriginalFunc    string `json:"originalFunc,omitempty"`    // main func name of the modified file before
+}
+
+type statFileFunc func(filename string) (os.FileInfo, error)
+
+// statFile substitution allows unit tests to generate manifests without touching the filesystem
+var statFile statFileFunc = os.Stat
+
+// readManifest loads the manifest at dir, or returns nil if there is none (or it fails to load it)
+func (srcs *Sources) readManifest(dir string) *manifest {
+	filename := filepath.Join(dir, ManifestFilename)
+	data, err := readFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		srcs.fail("Couldn't read manifest %s: %v", filename, err)
+		return nil
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		srcs.fail("Couldn't parse manifest %s: %v", filename, err)
+		return nil
+	}
+	return m
+}
+
+// addManifest generates the manifest at dir with the hashes of the given created and modified sources,
+// along with the created directories and the original names of modified files, as found in originals
+// or else recorded by any previous manifest.
+//
+// Created files already in the way fail, unless generated before: recorded as created by the previous
+// manifest or starting with the generated code Header.
+func (srcs *Sources) addManifest(dir string, created, modified []string, originals map[string]manifestEntry) {
+	previous := srcs.readManifest(dir)
+	m := &manifest{Files: make(map[string]*manifestEntry)}
+	dirs := make(map[string]bool)
+	if previous != nil {
+		for _, rel := range previous.Dirs {
+			dirs[rel] = true
+		}
+	}
+	for _, filename := range created {
+		if src := srcs.srcs[filename]; src != nil {
+			rel := relativeTo(dir, filename)
+			if !previous.created(rel) && !missingOrGenerated(filename) {
+				srcs.fail("Refusing to overwrite %s, it was not generated by bigbin", filename)
+				continue
+			}
+			m.Files[rel] = &manifestEntry{SHA256: hash(src), Created: true}
+			for parent := filepath.Dir(filename); !dirExists(parent); parent = filepath.Dir(parent) {
+				dirs[relativeTo(dir, parent)] = true
+			}
+		}
+	}
+	for rel := range dirs {
+		m.Dirs = append(m.Dirs, rel)
+	}
+	sort.Strings(m.Dirs)
+	for _, filename := range modified {
+		rel := relativeTo(dir, filename)
+		originalPackage, originalFunc := previous.original(rel)
+		if original, ok := originals[filename]; ok {
+			originalPackage, originalFunc = original.OriginalPackage, original.OriginalFunc
+		}
+		m.Files[rel] = &manifestEntry{
+			SHA256:          hash(srcs.srcs[filename]),
+			OriginalPackage: originalPackage,
+			OriginalFunc:    originalFunc,
+		}
+	}
+	src, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		srcs.fail("Couldn't generate manifest: %v", err)
+		return
+	}
+	srcs.srcs[filepath.Join(dir, ManifestFilename)] = append(src, '\n')
+}
+
+// removeCreated marks for deletion all files created at dir as recorded by its manifest m, and m itself,
+// refusing to delete those modified after being generated.
+//
+// When there is no manifest, the given legacy filenames are removed instead, but only if they look generated.
+func (srcs *Sources) removeCreated(dir string, m *manifest, legacy ...string) {
+	if m == nil {
+		for _, filename := range legacy {
+			src, err := readFile(filename)
+			if err == nil && !bytes.HasPrefix(src, []byte(Header)) {
+				srcs.fail("Refusing to delete %s, it was not generated by bigbin", filename)
+				continue
+			}
+			srcs.srcs[filename] = nil
+		}
+		return
+	}
+	for rel, entry := range m.Files {
+		if !entry.Created {
+			continue
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(rel))
+		src, err := readFile(filename)
+		if err == nil && hash(src) != entry.SHA256 {
+			srcs.fail("Refusing to delete %s, it was modified after being generated", filename)
+			continue
+		}
+		srcs.srcs[filename] = nil
+	}
+	for _, rel := range m.Dirs {
+		srcs.removableDirs[filepath.Join(dir, filepath.FromSlash(rel))] = true
+	}
+	srcs.srcs[filepath.Join(dir, ManifestFilename)] = nil
+}
+
+// missingOrGenerated tells whether filename does not exist or starts with the generated code Header
+func missingOrGenerated(filename string) bool {
+	src, err := readFile(filename)
+	return os.IsNotExist(err) || (err == nil && bytes.HasPrefix(src, []byte(Header)))
+}
+
+// dirExists tells whether dir exists already, the filesystem root and current directory always do
+func dirExists(dir string) bool {
+	if dir == filepath.Dir(dir) {
+		return true
+	}
+	_, err := statFile(dir)
+	return err == nil
+}
+
+// original returns the package and main func names the file had before being modified, main by default
+func (m *manifest) original(rel string) (packageName, funcName string) {
+	packageName, funcName = "main", "main"
+	if m == nil {
+		return
+	}
+	if entry, ok := m.Files[rel]; ok && entry.OriginalPackage != "" {
+		packageName, funcName = entry.OriginalPackage, entry.OriginalFunc
+	}
+	return
+}
+
+// created tells whether the manifest records rel as created by generation
+func (m *manifest) created(rel string) bool {
+	if m == nil {
+		return false
+	}
+	entry, ok := m.Files[rel]
+	return ok && entry.Created
+}
+
+// relativeTo returns filename as a slash separated path relative to dir
+func relativeTo(dir, filename string) string {
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// hash returns the hex encoded SHA256 of src
+func hash(src []byte) string {
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
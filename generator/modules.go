@@ -0,0 +1,159 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const GoModFilename = "go.mod"
+
+// module describes a go.mod file found enclosing some directory
+type module struct {
+	dir      string            // absolute directory holding the go.mod file
+	path     string            // module path as declared by the module directive
+	replaces map[string]string // local replace directives: module path -> absolute directory
+}
+
+type readFileFunc func(filename string) ([]byte, error)
+
+// readFile substitution allows unit tests to resolve go.mod files without touching the filesystem
+var readFile readFileFunc = os.ReadFile
+
+// modulesEnabled returns false only when go modules have been explicitly disabled with GO111MODULE=off
+func modulesEnabled() bool {
+	return os.Getenv("GO111MODULE") != "off"
+}
+
+// findModule walks up from the absolute directory dir looking for the nearest enclosing go.mod.
+// Returns nil without error if dir is not within any module.
+func findModule(dir string) (*module, error) {
+	dir = filepath.Clean(dir)
+	for {
+		gomod := filepath.Join(dir, GoModFilename)
+		data, err := readFile(gomod)
+		if err == nil {
+			return parseModule(dir, data)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// parseModule extracts the module path and local replace directives of a go.mod file contents
+func parseModule(dir string, data []byte) (*module, error) {
+	mod := &module{dir: dir, replaces: make(map[string]string)}
+	inReplaceBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case inReplaceBlock && fields[0] == ")":
+			inReplaceBlock = false
+		case inReplaceBlock:
+			mod.addReplace(fields)
+		case fields[0] == "module" && len(fields) == 2:
+			path, err := unquote(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: bad module path: %v", filepath.Join(dir, GoModFilename), lineno, err)
+			}
+			mod.path = path
+		case fields[0] == "replace" && len(fields) == 2 && fields[1] == "(":
+			inReplaceBlock = true
+		case fields[0] == "replace":
+			mod.addReplace(fields[1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if mod.path == "" {
+		return nil, fmt.Errorf("%s is missing the module directive", filepath.Join(dir, GoModFilename))
+	}
+	return mod, nil
+}
+
+// addReplace registers a replace directive such as 'old [version] => new [version]'
+// but only if it replaces to a local directory, as remote replacements do not alter local import paths
+func (mod *module) addReplace(fields []string) {
+	arrow := -1
+	for i, field := range fields {
+		if field == "=>" {
+			arrow = i
+		}
+	}
+	if arrow < 1 || arrow+1 >= len(fields) {
+		return
+	}
+	oldPath, err := unquote(fields[0])
+	if err != nil {
+		return
+	}
+	newPath, err := unquote(fields[arrow+1])
+	if err != nil || !isLocalPath(newPath) {
+		return
+	}
+	if !filepath.IsAbs(newPath) {
+		newPath = filepath.Join(mod.dir, newPath)
+	}
+	mod.replaces[oldPath] = filepath.Clean(newPath)
+}
+
+// importPath returns the import path for the absolute directory dir within this module
+func (mod *module) importPath(dir string) (string, bool) {
+	return joinImportPath(mod.path, mod.dir, dir)
+}
+
+// replacedImportPath returns the import path this module uses for the absolute directory dir
+// if dir falls within the local target of some of its replace directives
+func (mod *module) replacedImportPath(dir string) (string, bool) {
+	for oldPath, target := range mod.replaces {
+		if path, ok := joinImportPath(oldPath, target, dir); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// joinImportPath returns the import path of dir, if it is within root, being prefix the import path of root
+func joinImportPath(prefix, root, dir string) (string, bool) {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return prefix, true
+	}
+	return prefix + "/" + filepath.ToSlash(rel), true
+}
+
+// isLocalPath tells whether a replacement target is a filesystem path instead of a module path
+func isLocalPath(path string) bool {
+	return filepath.IsAbs(path) || path == "." || path == ".." ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+}
+
+// unquote removes go.mod optional quoting from a token
+func unquote(token string) (string, error) {
+	if strings.HasPrefix(token, `"`) || strings.HasPrefix(token, "`") {
+		return strconv.Unquote(token)
+	}
+	return token, nil
+}
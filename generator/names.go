@@ -0,0 +1,110 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// generatedImports are the package names imported by the generated autoregistration and standalone mains,
+// that the app package must not be named as
+var generatedImports = []string{"bigbin", "flag", "os"}
+
+// packageName derives a valid package name from the directory, parsing it, see safePackageName.
+// Prefer safePackageName when the directory packages were already parsed.
+func packageName(dir string) string {
+	packages, err := parseDir(token.NewFileSet(), dir)
+	if err != nil {
+		packages = nil
+	}
+	name, _ := safePackageName(dir, packages)
+	return name
+}
+
+// safePackageName derives a valid package name from the directory base name, replacing characters not valid
+// in identifiers by "_" and prefixing "app" if it does not start with a letter:
+// "./cmd/foo-bar" -> "foo_bar", "./cmd/2fa" -> "app2fa"
+//
+// Names that would still fail to compile or confuse, that is Go keywords, "main", which can't be imported, or
+// the name of any package imported by the app packages or the generated code, get "app" suffixed instead:
+// "./cmd/type" -> "typeapp", "./cmd/fmt" importing "fmt" -> "fmtapp".
+//
+// The reason why the name is not the directory base name is also returned, if so.
+func safePackageName(dir string, packages map[string]*ast.Package) (name, reason string) {
+	base := baseName(dir)
+	name = base
+	if !token.IsIdentifier(name) && !token.IsKeyword(name) {
+		name = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+				return r
+			}
+			return '_'
+		}, name)
+		if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+			name = "app" + name
+		}
+		reason = fmt.Sprintf("%q is not a valid identifier", base)
+	}
+	for {
+		switch {
+		case token.IsKeyword(name):
+			reason = fmt.Sprintf("%q is a Go keyword", name)
+		case name == "main":
+			reason = `"main" packages can't be imported`
+		case contains(generatedImports, name) || contains(importedNames(packages), name):
+			reason = fmt.Sprintf("%q collides with an imported package name", name)
+		default:
+			return name, reason
+		}
+		name += "app"
+	}
+}
+
+// importedNames returns the names of the packages imported by the non test files of packages
+func importedNames(packages map[string]*ast.Package) []string {
+	names := []string{}
+	for _, astpkg := range packages {
+		for filename, astfile := range astpkg.Files {
+			if strings.HasSuffix(filename, "_test.go") {
+				continue
+			}
+			for _, spec := range astfile.Imports {
+				importPath, err := strconv.Unquote(spec.Path.Value)
+				if err != nil {
+					continue
+				}
+				name := path.Base(importPath)
+				if spec.Name != nil {
+					name = spec.Name.Name
+				}
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// appName returns the name app is invoked as, the directory base name by default, that unlike its package
+// name may have dashes and such
+func appName(app App) string {
+	if app.Name != "" {
+		return app.Name
+	}
+	return baseName(app.Dir)
+}
+
+// baseName returns the last element of dir, resolving dir to an absolute path when it is just "." or ".."
+func baseName(dir string) string {
+	base := filepath.Base(dir)
+	if base == "." || base == ".." {
+		if abs, err := absPath(dir); err == nil {
+			base = filepath.Base(abs)
+		}
+	}
+	return base
+}
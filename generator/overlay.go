@@ -0,0 +1,177 @@
+package generator
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+const (
+	// OverlayDir is the shadow tree, within the bigbin directory, holding the transformed copies of the mains.
+	// As it starts with '_' the go tool ignores it when matching package patterns such as ./...
+	OverlayDir = "_overlay"
+
+	// OverlayFilename is the go build -overlay JSON file, within the bigbin directory
+	OverlayFilename = "overlay.json"
+)
+
+// overlayJSON is the format expected by go build -overlay
+type overlayJSON struct {
+	Replace map[string]string
+}
+
+// Overlay is a non destructive alternative to Generate that never modifies the original main packages.
+//
+// Instead, the transformed mains and their autoregistrations are generated as copies within a shadow tree at
+// {bigBinDir}/_overlay, and {bigBinDir}/overlay.json maps them over the original package directories,
+// so that the big binary is built with:
+//
+//	go build -overlay {bigBinDir}/overlay.json ./{bigBinDir}
+//
+// while each main package remains a regular standalone main. Hence, no standalone mains are generated.
+// The big binary main is shadowed too, as {bigBinDir}/_overlay/main.go mapped to {bigBinDir}/main.go,
+// because it imports the main packages: outside the overlay, it would break go build ./... and go vet ./...
+//
+// Filenames in overlay.json are relative to the module root, or to the current directory when not in module mode,
+// as go build resolves them against its working directory. So build from there, on any machine or checkout.
+func Overlay(bigBinDir string, mainDirs ...string) *Sources {
+	return OverlayApps(bigBinDir, Apps(mainDirs...)...)
+}
+
+// OverlayApps is Overlay for apps registered with other than the default names or descriptions
+func OverlayApps(bigBinDir string, apps ...App) *Sources {
+	srcs := newSources()
+	if bigBinDir == "" {
+		srcs.fail("Overlay requires a big binary directory")
+		return srcs
+	}
+	root, err := overlayRoot(bigBinDir)
+	if err != nil {
+		srcs.fail("Couldn't find the overlay root for %s: %v", bigBinDir, err)
+		return srcs
+	}
+	replace := make(map[string]string)
+	for _, app := range apps {
+		dir := app.Dir
+		applet := newSources()
+		info := applet.addFixedMains(dir)
+		applet.addAutoregistration(app, info)
+		srcs.notes = append(srcs.notes, applet.notes...)
+		if applet.errors != nil {
+			srcs.errors = append(srcs.errors, applet.errors...)
+			continue
+		}
+		srcs.addShadowed(bigBinDir, dir, root, applet, replace)
+	}
+	srcs.addShadowedBigBinMain(bigBinDir, root, dirs(apps), replace)
+	srcs.addOverlay(bigBinDir, replace)
+	srcs.addManifest(bigBinDir, srcs.Filenames(), nil, nil)
+	return srcs
+}
+
+// RestoreOverlay marks for removal all files generated by Overlay, as recorded by its manifest
+// unless they were modified afterwards. Without manifest, all files Overlay would generate are removed.
+func RestoreOverlay(bigBinDir string, mainDirs ...string) *Sources {
+	srcs := newSources()
+	if manifest := srcs.readManifest(bigBinDir); manifest != nil {
+		srcs.removeCreated(bigBinDir, manifest)
+		return srcs
+	}
+	srcs = Overlay(bigBinDir, mainDirs...)
+	for filename, _ := range srcs.srcs {
+		srcs.srcs[filename] = nil
+	}
+	return srcs
+}
+
+// overlayRoot returns the absolute directory overlay.json filenames are relative to: the module root
+// enclosing bigBinDir, or the current directory when not in module mode
+func overlayRoot(bigBinDir string) (string, error) {
+	if modulesEnabled() {
+		dir, err := absPath(bigBinDir)
+		if err != nil {
+			return "", err
+		}
+		mod, err := findModule(dir)
+		if err != nil {
+			return "", err
+		}
+		if mod != nil {
+			return mod.dir, nil
+		}
+	}
+	return absPath(".")
+}
+
+// addShadowed relocates the applet sources generated for dir into the bigbin shadow tree,
+// registering in replace the overlay mapping from each original filename to its copy, relative to root
+func (srcs *Sources) addShadowed(bigBinDir, dir, root string, applet *Sources, replace map[string]string) {
+	packagePath, err := importPath(bigBinDir, dir)
+	if err != nil {
+		srcs.fail("Couldn't get package path for %s: %v", dir, err)
+		return
+	}
+	shadowDir := filepath.Join(bigBinDir, OverlayDir, filepath.FromSlash(packagePath))
+	for filename, src := range applet.srcs {
+		shadow := filepath.Join(shadowDir, filepath.Base(filename))
+		original, err := rootRelative(root, filename)
+		if err != nil {
+			srcs.fail("Couldn't locate %s within %s: %v", filename, root, err)
+			return
+		}
+		shadowRel, err := rootRelative(root, shadow)
+		if err != nil {
+			srcs.fail("Couldn't locate %s within %s: %v", shadow, root, err)
+			return
+		}
+		srcs.srcs[shadow] = src
+		replace[original] = shadowRel
+	}
+}
+
+// addShadowedBigBinMain generates the big binary main for dirs within the bigbin shadow tree,
+// registering in replace its overlay mapping over {bigBinDir}/main.go, relative to root
+func (srcs *Sources) addShadowedBigBinMain(bigBinDir, root string, dirs []string, replace map[string]string) {
+	bigBin := newSources()
+	bigBin.addBigBinMain(bigBinDir, dirs)
+	if bigBin.errors != nil {
+		srcs.errors = append(srcs.errors, bigBin.errors...)
+		return
+	}
+	filename := bigBinFilename(bigBinDir)
+	shadow := filepath.Join(bigBinDir, OverlayDir, filepath.Base(filename))
+	original, err := rootRelative(root, filename)
+	if err != nil {
+		srcs.fail("Couldn't locate %s within %s: %v", filename, root, err)
+		return
+	}
+	shadowRel, err := rootRelative(root, shadow)
+	if err != nil {
+		srcs.fail("Couldn't locate %s within %s: %v", shadow, root, err)
+		return
+	}
+	srcs.srcs[shadow] = bigBin.srcs[filename]
+	replace[original] = shadowRel
+}
+
+// rootRelative returns filename relative to root, with forward slashes so that it works on any OS
+func rootRelative(root, filename string) (string, error) {
+	abs, err := absPath(filename)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// addOverlay generates the go build -overlay JSON file with the given replace mappings
+func (srcs *Sources) addOverlay(bigBinDir string, replace map[string]string) {
+	src, err := json.MarshalIndent(overlayJSON{Replace: replace}, "", "\t")
+	if err != nil {
+		srcs.fail("Couldn't generate overlay: %v", err)
+		return
+	}
+	srcs.srcs[filepath.Join(bigBinDir, OverlayFilename)] = append(src, '\n')
+}
@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SizeReport compares a big binary size with the sum of its apps standalone binaries sizes
+type SizeReport struct {
+	BigBinary   Artifact      // BigBinary built
+	StandAlones []Artifact    // StandAlones built for each app, in the big binary order
+	Packages    []PackageSize // Packages contributing to the big binary size, biggest first
+}
+
+// PackageSize is the size of the symbols of a package within a binary
+type PackageSize struct {
+	Package string
+	Size    int64
+}
+
+// Sum returns the size of all the standalone binaries together
+func (report *SizeReport) Sum() int64 {
+	sum := int64(0)
+	for _, standAlone := range report.StandAlones {
+		sum += standAlone.Size
+	}
+	return sum
+}
+
+// Savings returns the percentage of the standalones size saved by the big binary
+func (report *SizeReport) Savings() float64 {
+	sum := report.Sum()
+	if sum == 0 {
+		return 0
+	}
+	return 100 * float64(sum-report.BigBinary.Size) / float64(sum)
+}
+
+type goNmFunc func(binary string) ([]byte, error)
+
+// goNm substitution allows unit tests to test Size without running the go tool
+var goNm goNmFunc = defaultGoNm
+
+// Size builds bigBin and its apps standalones from srcs, as Build does but into a temporary directory,
+// and reports their sizes along with the size per package within the big binary, from its symbol table.
+// Platforms and output options are ignored, binaries are built for the host.
+// Fails if bigBin has no Dir or no apps, as there is nothing to compare then.
+func (srcs *Sources) Size(bigBin BigBinary, opts BuildOptions) (*SizeReport, error) {
+	if bigBin.Dir == "" || len(bigBin.Apps) == 0 {
+		return nil, fmt.Errorf("Size needs a big binary directory and apps to compare")
+	}
+	outDir, err := os.MkdirTemp("", "bigbin-size")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+	opts.OutDir, opts.StandAlones, opts.Platforms = outDir, true, nil
+	artifacts, err := srcs.Build([]BigBinary{bigBin}, opts)
+	if err != nil {
+		return nil, err
+	}
+	nm, err := goNm(artifacts[0].Path)
+	if err != nil {
+		return nil, err
+	}
+	return &SizeReport{BigBinary: artifacts[0], StandAlones: artifacts[1:], Packages: packageSizes(nm)}, nil
+}
+
+// packageSizes adds up the sizes of the symbols listed by "go tool nm -size" per package, biggest first.
+// Only text, read only and data symbols count, as BSS (zeroed at startup) and undefined ones take no file space.
+func packageSizes(nm []byte) []PackageSize {
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(nm))
+	for scanner.Scan() {
+		// address size type name, where undefined symbols have no address
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size == 0 || len(fields[2]) != 1 || !strings.Contains("TtRrDd", fields[2]) {
+			continue
+		}
+		sizes[symbolPackage(strings.Join(fields[3:], " "))] += size
+	}
+	packages := []PackageSize{}
+	for pkg, size := range sizes {
+		packages = append(packages, PackageSize{Package: pkg, Size: size})
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Size != packages[j].Size {
+			return packages[i].Size > packages[j].Size
+		}
+		return packages[i].Package < packages[j].Package
+	})
+	return packages
+}
+
+// symbolPackage returns the import path of the package a symbol belongs to, as in
+// "github.com/x/y.(*T).M" -> "github.com/x/y" or "type:*net/http.Client" -> "net/http".
+// Symbols generated by the toolchain and not attributable to a package are reported as "go:".
+func symbolPackage(symbol string) string {
+	name := strings.TrimLeft(strings.TrimPrefix(symbol, "type:"), "*")
+	if end := strings.IndexAny(name, "[(, "); end >= 0 {
+		name = name[:end] // leave out type parameters, receivers or signatures, that may name other packages
+	}
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot <= 0 || strings.HasPrefix(name, "go:") {
+		return "go:"
+	}
+	return name[:slash+1+dot]
+}
+
+// defaultGoNm lists the symbols of binary with their sizes, with go tool nm
+func defaultGoNm(binary string) ([]byte, error) {
+	return exec.Command("go", "tool", "nm", "-size", binary).Output()
+}
@@ -0,0 +1,243 @@
+package bigbin
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkMode tells how app names get installed pointing to the bigbin executable
+type linkMode int
+
+const (
+	symlinkMode  linkMode = iota // symbolic links, the default
+	hardlinkMode                 // hard links, only within the same filesystem
+	copyMode                     // full copies of the executable
+)
+
+// rootMain handles invocations of the bigbin by its own executable name, returning the exit status
+func rootMain(exe string, args []string) int {
+	rootName := filepath.Base(exe)
+	var install, uninstall, check, prune, symlinks, hardlinks, copies, force bool
+	var completion, genDocs string
+	flags := flag.NewFlagSet(rootName, flag.ContinueOnError)
+	flags.BoolVar(&install, "install", false, "Install all apps into DIR")
+	flags.BoolVar(&uninstall, "uninstall", false, "Remove from DIR all apps pointing to this binary")
+	flags.BoolVar(&check, "check-links", false, "Report stale and missing app links in DIR")
+	flags.BoolVar(&prune, "prune", false, "Remove stale app links from DIR")
+	flags.BoolVar(&symlinks, "s", false, "Install apps as symbolic links (default)")
+	flags.BoolVar(&hardlinks, "H", false, "Install apps as hard links")
+	flags.BoolVar(&copies, "c", false, "Install apps as copies of this binary")
+	flags.BoolVar(&force, "force", false, "Replace existing files when installing")
+	flags.StringVar(&completion, "completion", "", "Print the bash, zsh or fish completion script for all apps")
+	flags.StringVar(&genDocs, "gen-docs", "", "Write man or markdown pages for all apps into DIR")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n %s <app> [args...]\n", rootName)
+		fmt.Fprintf(os.Stderr, " %s --install [-s|-H|-c] [--force] DIR\n", rootName)
+		fmt.Fprintf(os.Stderr, " %s --uninstall DIR\n", rootName)
+		fmt.Fprintf(os.Stderr, " %s --check-links DIR\n", rootName)
+		fmt.Fprintf(os.Stderr, " %s --prune DIR\n", rootName)
+		fmt.Fprintf(os.Stderr, " %s --completion bash|zsh|fish\n", rootName)
+		fmt.Fprintf(os.Stderr, " %s --gen-docs man|markdown DIR\n", rootName)
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nRegistered apps are:\n")
+		listApps(os.Stderr)
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "%s app not added into this bigbin!\n", args[0])
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if genDocs != "" {
+		if countTrue(install, uninstall, check, prune, symlinks, hardlinks, copies, force) > 0 || completion != "" ||
+			flags.NArg() != 1 {
+			flags.Usage()
+			return 2
+		}
+		if err := writeDocs(rootName, genDocs, flags.Arg(0), flags); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if completion != "" {
+		if countTrue(install, uninstall, check, prune, symlinks, hardlinks, copies, force) > 0 || flags.NArg() > 0 {
+			flags.Usage()
+			return 2
+		}
+		if err := writeCompletion(os.Stdout, rootName, completion, flags); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if countTrue(install, uninstall, check, prune) != 1 || flags.NArg() != 1 || countTrue(symlinks, hardlinks, copies) > 1 {
+		flags.Usage()
+		return 2
+	}
+	dir := flags.Arg(0)
+	var err error
+	switch {
+	case install:
+		mode := symlinkMode
+		if hardlinks {
+			mode = hardlinkMode
+		} else if copies {
+			mode = copyMode
+		}
+		err = installApps(exe, dir, mode, force)
+	case uninstall:
+		err = uninstallApps(exe, dir)
+	case check:
+		err = checkLinks(exe, dir)
+	case prune:
+		err = pruneLinks(exe, dir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// installApps links or copies exe as every non hidden app name (and aliases) into dir.
+//
+// Existing files already pointing to exe are left alone, any other existing file is reported as a conflict,
+// unless force is set, in which case it gets replaced.
+func installApps(exe, dir string, mode linkMode, force bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var errs []error
+	for _, app := range Apps() {
+		for _, name := range app.Names() {
+			target := filepath.Join(dir, name)
+			if _, err := os.Lstat(target); err == nil {
+				if sameBinary(target, exe) && !force {
+					fmt.Printf(" %s already installed\n", target)
+					continue
+				}
+				if !force {
+					errs = append(errs, fmt.Errorf("%s already exists and is not this bigbin, use --force to replace it", target))
+					continue
+				}
+				if err := os.Remove(target); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			if err := link(exe, target, mode); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Printf(" %s -> %s\n", target, exe)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// uninstallApps removes from dir every app name (and aliases) that points to exe, leaving any other file alone
+func uninstallApps(exe, dir string) error {
+	var errs []error
+	for name, _ := range apps {
+		target := filepath.Join(dir, name)
+		if _, err := os.Lstat(target); err != nil {
+			continue
+		}
+		if !sameBinary(target, exe) {
+			fmt.Printf(" %s skipped, it is not this bigbin\n", target)
+			continue
+		}
+		if err := os.Remove(target); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf(" %s removed\n", target)
+	}
+	return errors.Join(errs...)
+}
+
+// link creates target pointing to exe as requested by mode
+func link(exe, target string, mode linkMode) error {
+	switch mode {
+	case hardlinkMode:
+		return os.Link(exe, target)
+	case copyMode:
+		return copyFile(exe, target)
+	default:
+		return os.Symlink(exe, target)
+	}
+}
+
+// copyFile copies the src executable into dst
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// sameBinary tells whether filename is exe, either as a symbolic link, a hard link or an identical copy
+func sameBinary(filename, exe string) bool {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	exeInfo, err := os.Stat(exe)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(info, exeInfo) {
+		return true
+	}
+	if info.Size() != exeInfo.Size() || !info.Mode().IsRegular() {
+		return false
+	}
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	exeContents, err := os.ReadFile(exe)
+	return err == nil && bytes.Equal(contents, exeContents)
+}
+
+// executable returns the absolute path of the bigbin executable, with all symbolic links resolved
+func executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+// countTrue counts how many of the given flags are set
+func countTrue(flags ...bool) int {
+	count := 0
+	for _, set := range flags {
+		if set {
+			count++
+		}
+	}
+	return count
+}
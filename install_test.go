@@ -0,0 +1,92 @@
+package bigbin
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// installedNames are all non hidden app names and aliases registered by the tests
+var installedNames = []string{"a", "app1", "app2", "b", "c", "cee", "exiter", "see"}
+
+// TestInstall checks apps get installed as symlinks or copies, and conflicts are reported unless forced
+func TestInstall(t *testing.T) {
+	exe, err := executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []string{"-s", "-c"} {
+		dir := t.TempDir()
+		conflict := filepath.Join(dir, "b")
+		if err := os.WriteFile(conflict, []byte("not me"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := Run(rootName, "--install", mode, dir); err == nil {
+			t.Fatalf("Install %s should have failed on conflict, but got: %s", mode, output)
+		} else if !strings.Contains(string(output), conflict+" already exists") {
+			t.Fatalf("Install %s should have reported the conflict, but got: %s", mode, output)
+		}
+		if output, err := Run(rootName, "--install", mode, "--force", dir); err != nil {
+			t.Fatalf("Forced install %s failed: %v: %s", mode, err, output)
+		}
+		assertInstalled(t, dir, exe, installedNames)
+		if output, err := Run(rootName, "--install", mode, dir); err != nil {
+			t.Fatalf("Reinstall %s should have succeeded: %v: %s", mode, err, output)
+		}
+	}
+}
+
+// TestUninstall checks only files pointing to this binary are removed
+func TestUninstall(t *testing.T) {
+	dir := t.TempDir()
+	if output, err := Run(rootName, "--install", dir); err != nil {
+		t.Fatalf("Install failed: %v: %s", err, output)
+	}
+	other := filepath.Join(dir, "see")
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, []byte("not me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := Run(rootName, "--uninstall", dir); err != nil {
+		t.Fatalf("Uninstall failed: %v: %s", err, output)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "see" {
+		t.Fatalf("Expected uninstall to only leave 'see' behind but got %v", entries)
+	}
+}
+
+// TestRootUsage checks wrong root invocations fail
+func TestRootUsage(t *testing.T) {
+	for _, args := range [][]string{{}, {"--install"}, {"--install", "--uninstall", "dir"}, {"--install", "-s", "-c", "dir"}} {
+		if output, err := Run(rootName, args...); err == nil {
+			t.Fatalf("Root invocation with %v should have failed, but got: %s", args, output)
+		}
+	}
+}
+
+// assertInstalled fails the test unless dir contains exactly names pointing to exe
+func assertInstalled(t *testing.T, dir, exe string, names []string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed := []string{}
+	for _, entry := range entries {
+		installed = append(installed, entry.Name())
+		if !sameBinary(filepath.Join(dir, entry.Name()), exe) {
+			t.Fatalf("%s does not point to %s", entry.Name(), exe)
+		}
+	}
+	sort.Strings(installed)
+	if strings.Join(installed, " ") != strings.Join(names, " ") {
+		t.Fatalf("Expected installed %v but got %v", names, installed)
+	}
+}
@@ -0,0 +1,89 @@
+package bigbin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// linkReport is the health report of the app links within a directory
+type linkReport struct {
+	stale   []string // symbolic links to this binary whose names are no longer registered
+	missing []string // registered app names not pointing to this binary
+}
+
+// scanLinks reports which symbolic links in dir resolve to exe but are not named after a registered app,
+// nor after the bigbin itself as exe or as invoked,
+// and which registered and non hidden app names (or aliases) in dir do not point to exe
+func scanLinks(exe, dir string) (*linkReport, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	report := &linkReport{}
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if _, ok := apps[entry.Name()]; ok {
+			continue
+		}
+		if entry.Name() == filepath.Base(exe) || entry.Name() == filepath.Base(os.Args[0]) {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		if resolved, err := filepath.EvalSymlinks(filename); err == nil && resolved == exe {
+			report.stale = append(report.stale, filename)
+		}
+	}
+	for _, app := range Apps() {
+		for _, name := range app.Names() {
+			if filename := filepath.Join(dir, name); !sameBinary(filename, exe) {
+				report.missing = append(report.missing, filename)
+			}
+		}
+	}
+	sort.Strings(report.missing)
+	return report, nil
+}
+
+// checkLinks prints the link health report of dir, failing if there are stale or missing links
+func checkLinks(exe, dir string) error {
+	report, err := scanLinks(exe, dir)
+	if err != nil {
+		return err
+	}
+	for _, filename := range report.stale {
+		fmt.Printf(" %s stale, app not added into this bigbin\n", filename)
+	}
+	for _, filename := range report.missing {
+		fmt.Printf(" %s missing\n", filename)
+	}
+	if len(report.stale) > 0 || len(report.missing) > 0 {
+		return fmt.Errorf("%s has %d stale and %d missing app links", dir, len(report.stale), len(report.missing))
+	}
+	fmt.Printf(" %s app links are all fine\n", dir)
+	return nil
+}
+
+// pruneLinks removes the stale links of dir, printing also the missing ones
+func pruneLinks(exe, dir string) error {
+	report, err := scanLinks(exe, dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, filename := range report.stale {
+		if err := os.Remove(filename); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf(" %s removed\n", filename)
+	}
+	for _, filename := range report.missing {
+		fmt.Printf(" %s missing\n", filename)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,62 @@
+package bigbin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckAndPruneLinks checks stale links are reported and pruned, and missing ones reported
+func TestCheckAndPruneLinks(t *testing.T) {
+	exe, err := executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if output, err := Run(rootName, "--install", dir); err != nil {
+		t.Fatalf("Install failed: %v: %s", err, output)
+	}
+	if output, err := Run(rootName, "--check-links", dir); err != nil {
+		t.Fatalf("Check links failed on a fresh install: %v: %s", err, output)
+	}
+	stale, missing, unrelated := filepath.Join(dir, "removed"), filepath.Join(dir, "a"), filepath.Join(dir, "other")
+	if err := os.Symlink(exe, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.DevNull, unrelated); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, rootName)
+	if err := os.Symlink(exe, root); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+	output, err := Run(rootName, "--check-links", dir)
+	if err == nil {
+		t.Fatalf("Check links should have failed, but got: %s", output)
+	}
+	for _, expected := range []string{stale + " stale", missing + " missing"} {
+		if !strings.Contains(string(output), expected) {
+			t.Fatalf("Check links should have reported '%s', but got: %s", expected, output)
+		}
+	}
+	for _, ignored := range []string{unrelated, root + " stale"} {
+		if strings.Contains(string(output), ignored) {
+			t.Fatalf("Check links should have ignored %s, but got: %s", ignored, output)
+		}
+	}
+	if output, err := Run(rootName, "--prune", dir); err != nil {
+		t.Fatalf("Prune failed: %v: %s", err, output)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Fatalf("Prune should have removed %s", stale)
+	}
+	for _, kept := range []string{unrelated, root} {
+		if _, err := os.Lstat(kept); err != nil {
+			t.Fatalf("Prune should have kept %s: %v", kept, err)
+		}
+	}
+}